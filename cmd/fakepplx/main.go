@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"pplx2api/fakepplx"
+	"pplx2api/logger"
+)
+
+// 启动本地的 Perplexity 模拟服务，供测试和预发环境使用
+func main() {
+	address := os.Getenv("FAKE_PPLX_ADDRESS")
+	if address == "" {
+		address = "127.0.0.1:9090"
+	}
+	logger.Info("Fake Perplexity server listening on %s", address)
+	if err := http.ListenAndServe(address, fakepplx.New()); err != nil {
+		logger.Fatal("Fake Perplexity server stopped: %v", err)
+	}
+}
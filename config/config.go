@@ -7,7 +7,7 @@ import (
 	"pplx2api/logger"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -15,27 +15,91 @@ import (
 
 type SessionInfo struct {
 	SessionKey string
+	Disabled   bool
+	// id 是会话的固定编号，刷新 key 后保持不变，删除后不再复用
+	id uint64
 }
 
-type SessionRagen struct {
-	Index int
-	Mutex sync.Mutex
-}
-
+// Config 是一份不可修改的配置快照，重新加载时整体替换，读取方每个请求只取一次快照
 type Config struct {
-	Sessions               []SessionInfo
 	Address                string
 	APIKey                 string
+	AdminKey               string
+	MetricsKey             string
+	KeysFile               string
+	APIKeys                []APIKeyInfo
 	Proxy                  string
 	IsIncognito            bool
 	MaxChatHistoryLength   int
-	RetryCount             int
 	NoRolePrefix           bool
 	SearchResultCompatible bool
 	PromptForFile          string
-	RwMutex                sync.RWMutex
 	IgnoreSerchResult      bool
+	SearchResultMarkdown   bool
+	ReasoningMode          string
 	IgnoreModelMonitoring  bool
+	PplxBaseURL            string
+	CloudinaryBaseURL      string
+	CloudinaryAssetURL     string
+	S3UploadURL            string
+	SessionCooldown        time.Duration
+	SessionMaxFailures     int
+	SessionProbeInterval   time.Duration
+	RateLimitRPM           int
+	RateLimitStreams       int
+	LogLevel               string
+	LogFormat              string
+	ShutdownTimeout        time.Duration
+	UpstreamTimeout        time.Duration
+	UpstreamHeaderTimeout  time.Duration
+	ConfigFile             string
+	ModelMap               map[string]string
+	ConfigWatchInterval    time.Duration
+	ImageFetchMaxMB        int
+	ImageFetchTimeout      time.Duration
+	ImageFetchProxy        string
+	ImageFetchAllowPrivate bool
+	ImageTranscode         bool
+	ImageMaxDimension      int
+	ImageMaxPixels         int
+	AttachmentCacheSize    int
+	AttachmentCacheTTL     time.Duration
+	FilesDir               string
+	FilesMaxMB             int
+	RequestMaxMB           int
+	// sourceSessions 来自环境变量或配置文件的会话，重新加载时据此增删会话
+	sourceSessions []SessionInfo
+	// sessions 运行时可修改的会话列表，重新加载后的配置沿用同一个列表
+	sessions *sessionStore
+}
+
+// fileEnv holds the values of the config file, keyed by environment variable
+var fileEnv = map[string]string{}
+
+// getEnv returns the environment variable, falling back to the config file value
+func getEnv(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fileEnv[key]
+}
+
+const (
+	// DefaultModel 请求未指定模型时使用的模型
+	DefaultModel              = "claude-3.7-sonnet"
+	DefaultPplxBaseURL        = "https://www.perplexity.ai"
+	DefaultCloudinaryBaseURL  = "https://api.cloudinary.com"
+	DefaultCloudinaryAssetURL = "https://pplx-res.cloudinary.com/image/private"
+	DefaultS3UploadURL        = "https://ppl-ai-file-upload.s3.amazonaws.com/"
+)
+
+// 读取上游地址，去掉末尾的斜杠
+func getBaseURLEnv(key string, defaultValue string) string {
+	value := strings.TrimRight(getEnv(key), "/")
+	if value == "" {
+		return strings.TrimRight(defaultValue, "/")
+	}
+	return value
 }
 
 // 解析 SESSION 格式的环境变量
@@ -60,97 +124,312 @@ func parseSessionEnv(envValue string) (int, []SessionInfo) {
 	return retryCount, sessions
 }
 
-// 根据模型选择合适的 session
-func (c *Config) GetSessionForModel(idx int) (SessionInfo, error) {
-	if len(c.Sessions) == 0 || idx < 0 || idx >= len(c.Sessions) {
-		return SessionInfo{}, fmt.Errorf("invalid session index: %d", idx)
+// 读取时间配置，可以写作秒数或 500ms、2m 这样的时长
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	value, err := parseDuration(getEnv(key))
+	if err != nil || value <= 0 {
+		return defaultValue
 	}
-	c.RwMutex.RLock()
-	defer c.RwMutex.RUnlock()
-	return c.Sessions[idx], nil
+	return value
 }
 
-// 从环境变量加载配置
+// 从环境变量加载配置，出错时退出程序
 func LoadConfig() *Config {
-	maxChatHistoryLength, err := strconv.Atoi(os.Getenv("MAX_CHAT_HISTORY_LENGTH"))
+	config, err := loadConfig()
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to load config: %v", err))
+	}
+	applyLogSettings(config)
+	return config
+}
+
+// applyLogSettings 设置日志级别和格式
+func applyLogSettings(c *Config) {
+	level, _ := logger.ParseLevel(c.LogLevel)
+	logger.SetLevel(level)
+	logger.SetFormat(c.LogFormat)
+}
+
+// loadConfig 从环境变量和配置文件加载配置
+func loadConfig() (config *Config, err error) {
+	// 读取可选的配置文件，环境变量优先于配置文件
+	configFile := ConfigFilePath()
+	fileConfig := &FileConfig{}
+	if configFile != "" {
+		fileConfig, err = LoadConfigFile(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config file: %w", err)
+		}
+	}
+	previousEnv := fileEnv
+	fileEnv = fileConfig.env()
+	defer func() {
+		// 加载失败时保留之前的配置文件内容
+		if err != nil {
+			fileEnv = previousEnv
+		}
+	}()
+	// 环境变量和配置文件合并后使用同一套校验，非法值直接报错而不是使用默认值
+	if _, err = mergedFileConfig(fileConfig); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	logLevel := strings.ToUpper(getEnv("LOG_LEVEL"))
+	if _, ok := logger.ParseLevel(logLevel); !ok {
+		logLevel = logger.GetLevelName(logger.INFO) // 默认值
+	}
+	logFormat := strings.ToLower(getEnv("LOG_FORMAT"))
+	if logFormat != logger.FormatJSON {
+		logFormat = logger.FormatText // 默认值
+	}
+	maxChatHistoryLength, err := strconv.Atoi(getEnv("MAX_CHAT_HISTORY_LENGTH"))
 	if err != nil {
 		maxChatHistoryLength = 10000 // 默认值
 	}
-	retryCount, sessions := parseSessionEnv(os.Getenv("SESSIONS"))
-	promptForFile := os.Getenv("PROMPT_FOR_FILE")
+	_, sessions := parseSessionEnv(getEnv("SESSIONS"))
+	sessionMaxFailures, err := strconv.Atoi(getEnv("SESSION_MAX_FAILURES"))
+	if err != nil || sessionMaxFailures <= 0 {
+		sessionMaxFailures = 3 // 默认值
+	}
+	reasoningMode := getEnv("REASONING_MODE")
+	if reasoningMode == "" {
+		reasoningMode = "think" // 默认值
+	}
+	keysFile := getEnv("KEYS_FILE")
+	if keysFile == "" {
+		keysFile = "keys.json" // 默认值
+	}
+	apiKeys, err := LoadAPIKeys(keysFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API keys: %w", err)
+	}
+	// 合并配置文件中的密钥
+	for _, key := range fileConfig.APIKeys {
+		for _, existing := range apiKeys {
+			if existing.Key == key.Key {
+				return nil, fmt.Errorf("API key %q is defined in both %s and %s", key.Label, configFile, keysFile)
+			}
+		}
+		apiKeys = append(apiKeys, key)
+	}
+	// 配置文件中的模型映射覆盖或补充内置映射
+	modelMap := make(map[string]string, len(DefaultModelMap)+len(fileConfig.ModelMap))
+	for name, pplxModel := range DefaultModelMap {
+		modelMap[name] = pplxModel
+	}
+	for name, pplxModel := range fileConfig.ModelMap {
+		modelMap[name] = pplxModel
+	}
+	configWatchInterval := 5 * time.Second // 默认值
+	if value, err := parseDuration(getEnv("CONFIG_WATCH_INTERVAL")); err == nil && value >= 0 {
+		configWatchInterval = value
+	}
+	rateLimitRPM, err := strconv.Atoi(getEnv("RATE_LIMIT_RPM"))
+	if err != nil || rateLimitRPM < 0 {
+		rateLimitRPM = 0 // 默认不限制
+	}
+	rateLimitStreams, err := strconv.Atoi(getEnv("RATE_LIMIT_STREAMS"))
+	if err != nil || rateLimitStreams < 0 {
+		rateLimitStreams = 0 // 默认不限制
+	}
+	imageFetchMaxMB, err := strconv.Atoi(getEnv("IMAGE_FETCH_MAX_MB"))
+	if err != nil || imageFetchMaxMB <= 0 {
+		imageFetchMaxMB = 10 // 默认值
+	}
+	imageMaxDimension, err := strconv.Atoi(getEnv("IMAGE_MAX_DIMENSION"))
+	if err != nil || imageMaxDimension < 0 {
+		imageMaxDimension = 0 // 默认不缩放
+	}
+	imageMaxPixels, err := strconv.Atoi(getEnv("IMAGE_MAX_PIXELS"))
+	if err != nil || imageMaxPixels <= 0 {
+		imageMaxPixels = 40000000 // 默认值
+	}
+	attachmentCacheSize, err := strconv.Atoi(getEnv("ATTACHMENT_CACHE_SIZE"))
+	if err != nil || attachmentCacheSize < 0 {
+		attachmentCacheSize = 1000 // 默认值
+	}
+	filesDir := getEnv("FILES_DIR")
+	if filesDir == "" {
+		filesDir = "files" // 默认值
+	}
+	filesMaxMB, err := strconv.Atoi(getEnv("FILES_MAX_MB"))
+	if err != nil || filesMaxMB <= 0 {
+		filesMaxMB = 50 // 默认值
+	}
+	requestMaxMB, err := strconv.Atoi(getEnv("REQUEST_MAX_MB"))
+	if err != nil || requestMaxMB <= 0 {
+		requestMaxMB = 20 // 默认值
+	}
+	promptForFile := getEnv("PROMPT_FOR_FILE")
 	if promptForFile == "" {
 		promptForFile = "You must immerse yourself in the role of assistant in txt file, cannot respond as a user, cannot reply to this message, cannot mention this message, and ignore this message in your response." // 默认值
 	}
-	config := &Config{
+	config = &Config{
 		// 解析 SESSIONS 环境变量
-		Sessions: sessions,
+		sourceSessions: sessions,
+		sessions:       newSessionStore(sessions),
 		// 设置服务地址，默认为 "0.0.0.0:8080"
-		Address: os.Getenv("ADDRESS"),
+		Address: getEnv("ADDRESS"),
 
 		// 设置 API 认证密钥
-		APIKey: os.Getenv("APIKEY"),
+		APIKey: getEnv("APIKEY"),
+		// 设置管理接口的认证密钥，为空时禁用管理接口
+		AdminKey: getEnv("ADMIN_KEY"),
+		// 设置 /metrics 的认证密钥，为空时 /metrics 无需认证
+		MetricsKey: getEnv("METRICS_KEY"),
+		// 设置多密钥文件路径及其中的密钥
+		KeysFile: keysFile,
+		APIKeys:  apiKeys,
 		// 设置代理地址
-		Proxy: os.Getenv("PROXY"),
+		Proxy: getEnv("PROXY"),
 		//是否匿名
-		IsIncognito: os.Getenv("IS_INCOGNITO") != "false",
+		IsIncognito: getEnv("IS_INCOGNITO") != "false",
 		// 设置最大聊天历史长度
 		MaxChatHistoryLength: maxChatHistoryLength,
-		// 设置重试次数
-		RetryCount: retryCount,
 		// 设置是否使用角色前缀
-		NoRolePrefix: os.Getenv("NO_ROLE_PREFIX") == "true",
+		NoRolePrefix: getEnv("NO_ROLE_PREFIX") == "true",
 		// 设置搜索结果兼容性
-		SearchResultCompatible: os.Getenv("SEARCH_RESULT_COMPATIBLE") == "true",
+		SearchResultCompatible: getEnv("SEARCH_RESULT_COMPATIBLE") == "true",
 		// 设置上传文件后的提示词
 		PromptForFile: promptForFile,
 		// 设置是否忽略搜索结果
-		IgnoreSerchResult: os.Getenv("IGNORE_SEARCH_RESULT") == "true",
+		IgnoreSerchResult: getEnv("IGNORE_SEARCH_RESULT") == "true",
+		// 设置是否在回答末尾以 markdown 展示搜索结果
+		SearchResultMarkdown: getEnv("SEARCH_RESULT_MARKDOWN") != "false",
+		// 设置思考过程的输出方式：think、reasoning_content 或 hidden
+		ReasoningMode: reasoningMode,
 		//设置是否忽略模型监控
-		IgnoreModelMonitoring: os.Getenv("IGNORE_MODEL_MONITORING") == "true",
-		// 读写锁
-		RwMutex: sync.RWMutex{},
+		IgnoreModelMonitoring: getEnv("IGNORE_MODEL_MONITORING") == "true",
+		// 设置 Perplexity 上游地址
+		PplxBaseURL: getBaseURLEnv("PPLX_BASE_URL", DefaultPplxBaseURL),
+		// 设置 Cloudinary 图片上传地址
+		CloudinaryBaseURL: getBaseURLEnv("CLOUDINARY_BASE_URL", DefaultCloudinaryBaseURL),
+		// 设置上传后图片的访问地址前缀
+		CloudinaryAssetURL: getBaseURLEnv("CLOUDINARY_ASSET_URL", DefaultCloudinaryAssetURL),
+		// 设置 S3 文件上传地址
+		S3UploadURL: getBaseURLEnv("S3_UPLOAD_URL", DefaultS3UploadURL) + "/",
+		// 设置会话限流后的冷却时间
+		SessionCooldown: getDurationEnv("SESSION_COOLDOWN", time.Minute),
+		// 设置会话连续失败多少次后冷却或退出轮询
+		SessionMaxFailures: sessionMaxFailures,
+		// 设置失效会话的探测间隔
+		SessionProbeInterval: getDurationEnv("SESSION_PROBE_INTERVAL", 10*time.Minute),
+		// 设置每个客户端每分钟的请求数和并发流数量上限
+		RateLimitRPM:     rateLimitRPM,
+		RateLimitStreams: rateLimitStreams,
+		// 设置日志级别和格式
+		LogLevel:  logLevel,
+		LogFormat: logFormat,
+		// 设置关闭时等待进行中请求的最长时间
+		ShutdownTimeout: getDurationEnv("SHUTDOWN_TIMEOUT", 30*time.Second),
+		// 设置上游请求的总超时和响应头超时
+		UpstreamTimeout:       getDurationEnv("UPSTREAM_TIMEOUT", 10*time.Minute),
+		UpstreamHeaderTimeout: getDurationEnv("UPSTREAM_HEADER_TIMEOUT", 10*time.Second),
+		// 配置文件路径和模型映射
+		ConfigFile: configFile,
+		ModelMap:   modelMap,
+		// 设置配置文件的检查间隔，0 表示不监视
+		ConfigWatchInterval: configWatchInterval,
+		// 设置下载远程图片的大小上限、超时和代理，默认禁止访问内网地址
+		ImageFetchMaxMB:        imageFetchMaxMB,
+		ImageFetchTimeout:      getDurationEnv("IMAGE_FETCH_TIMEOUT", 30*time.Second),
+		ImageFetchProxy:        getEnv("IMAGE_FETCH_PROXY"),
+		ImageFetchAllowPrivate: getEnv("IMAGE_FETCH_ALLOW_PRIVATE") == "true",
+		// 设置是否将 BMP、TIFF 等格式转换为 PNG 或 JPEG，以及图片最长边的像素上限
+		ImageTranscode:    getEnv("IMAGE_TRANSCODE") != "false",
+		ImageMaxDimension: imageMaxDimension,
+		// 设置解码图片的像素上限，超出时拒绝，防止伪造的图片尺寸耗尽内存
+		ImageMaxPixels: imageMaxPixels,
+		// 设置已上传附件缓存的条目上限和有效期，0 表示不缓存
+		AttachmentCacheSize: attachmentCacheSize,
+		AttachmentCacheTTL:  getDurationEnv("ATTACHMENT_CACHE_TTL", time.Hour),
+		// 设置 Files API 的存储目录和单个文件的大小上限
+		FilesDir:   filesDir,
+		FilesMaxMB: filesMaxMB,
+		// 设置 JSON 请求体的大小上限，限流和鉴权前读取请求体时生效
+		RequestMaxMB: requestMaxMB,
 	}
 
 	// 如果地址为空，使用默认值
 	if config.Address == "" {
 		config.Address = "0.0.0.0:8080"
 	}
-	return config
+	return config, nil
 }
 
-var ConfigInstance *Config
-var Sr *SessionRagen
+// current 当前生效的配置，重新加载时原子替换
+var current atomic.Pointer[Config]
+
+// Current 返回当前的配置快照，快照不会被修改，同一个请求应只读取一次
+func Current() *Config {
+	return current.Load()
+}
 
-func (sr *SessionRagen) NextIndex() int {
-	sr.Mutex.Lock()
-	defer sr.Mutex.Unlock()
+// Store 发布新的配置快照，新配置沿用当前的会话列表
+func Store(c *Config) {
+	if previous := current.Load(); previous != nil {
+		c.sessions = previous.sessions
+	}
+	current.Store(c)
+}
 
-	index := sr.Index
-	sr.Index = (index + 1) % len(ConfigInstance.Sessions)
-	return index
+// Clone 返回配置的浅拷贝，用于修改后通过 Store 发布
+func (c *Config) Clone() *Config {
+	clone := *c
+	return &clone
 }
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 	// 加载环境变量
 	_ = godotenv.Load()
-	Sr = &SessionRagen{
-		Index: 0,
-		Mutex: sync.Mutex{},
-	}
-	ConfigInstance = LoadConfig()
+	cfg := LoadConfig()
+	current.Store(cfg)
+	SetModelMap(cfg.ModelMap)
 	logger.Info("Loaded config:")
-	logger.Info(fmt.Sprintf("Sessions count: %d", ConfigInstance.RetryCount))
-	for _, session := range ConfigInstance.Sessions {
-		logger.Info(fmt.Sprintf("Session: %s", session.SessionKey))
-	}
-	logger.Info(fmt.Sprintf("Address: %s", ConfigInstance.Address))
-	logger.Info(fmt.Sprintf("APIKey: %s", ConfigInstance.APIKey))
-	logger.Info(fmt.Sprintf("Proxy: %s", ConfigInstance.Proxy))
-	logger.Info(fmt.Sprintf("IsIncognito: %t", ConfigInstance.IsIncognito))
-	logger.Info(fmt.Sprintf("MaxChatHistoryLength: %d", ConfigInstance.MaxChatHistoryLength))
-	logger.Info(fmt.Sprintf("NoRolePrefix: %t", ConfigInstance.NoRolePrefix))
-	logger.Info(fmt.Sprintf("SearchResultCompatible: %t", ConfigInstance.SearchResultCompatible))
-	logger.Info(fmt.Sprintf("PromptForFile: %s", ConfigInstance.PromptForFile))
-	logger.Info(fmt.Sprintf("IgnoreSerchResult: %t", ConfigInstance.IgnoreSerchResult))
-	logger.Info(fmt.Sprintf("IgnoreModelMonitoring: %t", ConfigInstance.IgnoreModelMonitoring))
+	logger.Info(fmt.Sprintf("Sessions count: %d", cfg.SessionCount()))
+	for _, session := range cfg.SessionsSnapshot() {
+		logger.Info(fmt.Sprintf("Session: %s", logger.Secret(session.SessionKey)))
+	}
+	logger.Info(fmt.Sprintf("Address: %s", cfg.Address))
+	logger.Info(fmt.Sprintf("APIKey: %s", logger.Secret(cfg.APIKey)))
+	logger.Info(fmt.Sprintf("API keys: %d (keys file: %s)", len(cfg.APIKeys), cfg.KeysFile))
+	logger.Info(fmt.Sprintf("Proxy: %s", logger.RedactURL(cfg.Proxy)))
+	logger.Info(fmt.Sprintf("IsIncognito: %t", cfg.IsIncognito))
+	logger.Info(fmt.Sprintf("MaxChatHistoryLength: %d", cfg.MaxChatHistoryLength))
+	logger.Info(fmt.Sprintf("NoRolePrefix: %t", cfg.NoRolePrefix))
+	logger.Info(fmt.Sprintf("SearchResultCompatible: %t", cfg.SearchResultCompatible))
+	logger.Info(fmt.Sprintf("PromptForFile: %s", cfg.PromptForFile))
+	logger.Info(fmt.Sprintf("IgnoreSerchResult: %t", cfg.IgnoreSerchResult))
+	logger.Info(fmt.Sprintf("SearchResultMarkdown: %t", cfg.SearchResultMarkdown))
+	logger.Info(fmt.Sprintf("ReasoningMode: %s", cfg.ReasoningMode))
+	logger.Info(fmt.Sprintf("IgnoreModelMonitoring: %t", cfg.IgnoreModelMonitoring))
+	logger.Info(fmt.Sprintf("PplxBaseURL: %s", cfg.PplxBaseURL))
+	logger.Info(fmt.Sprintf("CloudinaryBaseURL: %s", cfg.CloudinaryBaseURL))
+	logger.Info(fmt.Sprintf("CloudinaryAssetURL: %s", cfg.CloudinaryAssetURL))
+	logger.Info(fmt.Sprintf("S3UploadURL: %s", cfg.S3UploadURL))
+	logger.Info(fmt.Sprintf("SessionCooldown: %s", cfg.SessionCooldown))
+	logger.Info(fmt.Sprintf("SessionMaxFailures: %d", cfg.SessionMaxFailures))
+	logger.Info(fmt.Sprintf("SessionProbeInterval: %s", cfg.SessionProbeInterval))
+	logger.Info(fmt.Sprintf("RateLimitRPM: %d", cfg.RateLimitRPM))
+	logger.Info(fmt.Sprintf("RateLimitStreams: %d", cfg.RateLimitStreams))
+	logger.Info(fmt.Sprintf("LogLevel: %s", cfg.LogLevel))
+	logger.Info(fmt.Sprintf("LogFormat: %s", cfg.LogFormat))
+	logger.Info(fmt.Sprintf("ShutdownTimeout: %s", cfg.ShutdownTimeout))
+	logger.Info(fmt.Sprintf("UpstreamTimeout: %s", cfg.UpstreamTimeout))
+	logger.Info(fmt.Sprintf("UpstreamHeaderTimeout: %s", cfg.UpstreamHeaderTimeout))
+	logger.Info(fmt.Sprintf("ConfigFile: %s", cfg.ConfigFile))
+	logger.Info(fmt.Sprintf("ConfigWatchInterval: %s", cfg.ConfigWatchInterval))
+	logger.Info(fmt.Sprintf("ImageFetchMaxMB: %d", cfg.ImageFetchMaxMB))
+	logger.Info(fmt.Sprintf("ImageFetchTimeout: %s", cfg.ImageFetchTimeout))
+	logger.Info(fmt.Sprintf("ImageFetchProxy: %s", logger.RedactURL(cfg.ImageFetchProxy)))
+	logger.Info(fmt.Sprintf("ImageFetchAllowPrivate: %t", cfg.ImageFetchAllowPrivate))
+	logger.Info(fmt.Sprintf("ImageTranscode: %t", cfg.ImageTranscode))
+	logger.Info(fmt.Sprintf("ImageMaxDimension: %d", cfg.ImageMaxDimension))
+	logger.Info(fmt.Sprintf("ImageMaxPixels: %d", cfg.ImageMaxPixels))
+	logger.Info(fmt.Sprintf("AttachmentCacheSize: %d", cfg.AttachmentCacheSize))
+	logger.Info(fmt.Sprintf("AttachmentCacheTTL: %s", cfg.AttachmentCacheTTL))
+	logger.Info(fmt.Sprintf("FilesDir: %s", cfg.FilesDir))
+	logger.Info(fmt.Sprintf("FilesMaxMB: %d", cfg.FilesMaxMB))
+	logger.Info(fmt.Sprintf("RequestMaxMB: %d", cfg.RequestMaxMB))
+	logger.Info(fmt.Sprintf("Models: %d", len(cfg.ModelMap)))
 }
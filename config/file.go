@@ -0,0 +1,419 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"pplx2api/logger"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration written as "30s" or "10m" in the config file
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// FileConfig is the schema of the optional YAML or TOML config file.
+// Every value can be overridden by the matching environment variable.
+type FileConfig struct {
+	Address              string            `yaml:"address" toml:"address"`
+	APIKey               string            `yaml:"api_key" toml:"api_key"`
+	AdminKey             string            `yaml:"admin_key" toml:"admin_key"`
+	MetricsKey           string            `yaml:"metrics_key" toml:"metrics_key"`
+	KeysFile             string            `yaml:"keys_file" toml:"keys_file"`
+	APIKeys              []APIKeyInfo      `yaml:"api_keys" toml:"api_keys"`
+	Proxy                string            `yaml:"proxy" toml:"proxy"`
+	Sessions             []string          `yaml:"sessions" toml:"sessions"`
+	ModelMap             map[string]string `yaml:"model_map" toml:"model_map"`
+	IsIncognito          *bool             `yaml:"is_incognito" toml:"is_incognito"`
+	MaxChatHistoryLength *int              `yaml:"max_chat_history_length" toml:"max_chat_history_length"`
+	NoRolePrefix         *bool             `yaml:"no_role_prefix" toml:"no_role_prefix"`
+	PromptForFile        string            `yaml:"prompt_for_file" toml:"prompt_for_file"`
+	ReasoningMode        string            `yaml:"reasoning_mode" toml:"reasoning_mode"`
+	Search               struct {
+		IgnoreSearchResult     *bool `yaml:"ignore_search_result" toml:"ignore_search_result"`
+		SearchResultCompatible *bool `yaml:"search_result_compatible" toml:"search_result_compatible"`
+		SearchResultMarkdown   *bool `yaml:"search_result_markdown" toml:"search_result_markdown"`
+		IgnoreModelMonitoring  *bool `yaml:"ignore_model_monitoring" toml:"ignore_model_monitoring"`
+	} `yaml:"search" toml:"search"`
+	Upstream struct {
+		PplxBaseURL        string `yaml:"pplx_base_url" toml:"pplx_base_url"`
+		CloudinaryBaseURL  string `yaml:"cloudinary_base_url" toml:"cloudinary_base_url"`
+		CloudinaryAssetURL string `yaml:"cloudinary_asset_url" toml:"cloudinary_asset_url"`
+		S3UploadURL        string `yaml:"s3_upload_url" toml:"s3_upload_url"`
+	} `yaml:"upstream" toml:"upstream"`
+	SessionMaxFailures *int `yaml:"session_max_failures" toml:"session_max_failures"`
+	Timeouts           struct {
+		Upstream             *Duration `yaml:"upstream" toml:"upstream"`
+		UpstreamHeader       *Duration `yaml:"upstream_header" toml:"upstream_header"`
+		SessionCooldown      *Duration `yaml:"session_cooldown" toml:"session_cooldown"`
+		SessionProbeInterval *Duration `yaml:"session_probe_interval" toml:"session_probe_interval"`
+		Shutdown             *Duration `yaml:"shutdown" toml:"shutdown"`
+		ConfigWatchInterval  *Duration `yaml:"config_watch_interval" toml:"config_watch_interval"`
+		ImageFetch           *Duration `yaml:"image_fetch" toml:"image_fetch"`
+		AttachmentCache      *Duration `yaml:"attachment_cache" toml:"attachment_cache"`
+	} `yaml:"timeouts" toml:"timeouts"`
+	ImageFetch struct {
+		MaxMB        *int   `yaml:"max_mb" toml:"max_mb"`
+		Proxy        string `yaml:"proxy" toml:"proxy"`
+		AllowPrivate *bool  `yaml:"allow_private" toml:"allow_private"`
+	} `yaml:"image_fetch" toml:"image_fetch"`
+	Image struct {
+		Transcode    *bool `yaml:"transcode" toml:"transcode"`
+		MaxDimension *int  `yaml:"max_dimension" toml:"max_dimension"`
+		MaxPixels    *int  `yaml:"max_pixels" toml:"max_pixels"`
+	} `yaml:"image" toml:"image"`
+	AttachmentCacheSize *int `yaml:"attachment_cache_size" toml:"attachment_cache_size"`
+	Files               struct {
+		Dir   string `yaml:"dir" toml:"dir"`
+		MaxMB *int   `yaml:"max_mb" toml:"max_mb"`
+	} `yaml:"files" toml:"files"`
+	RequestMaxMB *int `yaml:"request_max_mb" toml:"request_max_mb"`
+	RateLimit    struct {
+		RPM     *int `yaml:"rpm" toml:"rpm"`
+		Streams *int `yaml:"streams" toml:"streams"`
+	} `yaml:"rate_limit" toml:"rate_limit"`
+	Log struct {
+		Level  string `yaml:"level" toml:"level"`
+		Format string `yaml:"format" toml:"format"`
+	} `yaml:"log" toml:"log"`
+}
+
+// DefaultConfigFiles are looked up in the working directory when CONFIG_FILE is not set
+var DefaultConfigFiles = []string{"config.yaml", "config.yml", "config.toml"}
+
+// ConfigFilePath returns the config file to load, or "" when there is none
+func ConfigFilePath() string {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path
+	}
+	for _, path := range DefaultConfigFiles {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// LoadConfigFile parses and validates a YAML or TOML config file, unknown keys are rejected
+func LoadConfigFile(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fileConfig := &FileConfig{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(fileConfig); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(fileConfig); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file %s, expected .yaml, .yml or .toml", path)
+	}
+	if err := fileConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return fileConfig, nil
+}
+
+// Validate checks every value set in the file and reports all problems at once
+func (f *FileConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	if f.Address != "" {
+		_, _, err := net.SplitHostPort(f.Address)
+		check(err == nil, "address %q must be host:port", f.Address)
+	}
+	if f.Proxy != "" {
+		u, err := url.Parse(f.Proxy)
+		check(err == nil && u.Host != "" && (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "socks5"),
+			"proxy %q must be an http, https or socks5 URL", logger.RedactURL(f.Proxy))
+	}
+	for i, session := range f.Sessions {
+		check(strings.TrimSpace(session) != "" && !strings.Contains(session, ","), "sessions[%d] must be a non-empty token without commas", i)
+	}
+	seenKeys := map[string]bool{}
+	for i, key := range f.APIKeys {
+		check(key.Key != "", "api_keys[%d].key must not be empty", i)
+		check(!seenKeys[key.Key], "api_keys[%d] (%s) is duplicated", i, key.Label)
+		check(key.DailyRequests >= 0 && key.DailyTokens >= 0, "api_keys[%d] quotas must not be negative", i)
+		seenKeys[key.Key] = true
+	}
+	for name, pplxModel := range f.ModelMap {
+		check(name != "" && pplxModel != "", "model_map entries must have a name and a Perplexity model")
+		check(!strings.HasSuffix(name, "-search"), "model_map name %q must not end with -search, search variants are added automatically", name)
+	}
+	if f.MaxChatHistoryLength != nil {
+		check(*f.MaxChatHistoryLength > 0, "max_chat_history_length must be positive")
+	}
+	if f.ReasoningMode != "" {
+		switch f.ReasoningMode {
+		case "think", "reasoning_content", "hidden":
+		default:
+			check(false, "reasoning_mode %q must be think, reasoning_content or hidden", f.ReasoningMode)
+		}
+	}
+	for name, value := range map[string]string{
+		"upstream.pplx_base_url":        f.Upstream.PplxBaseURL,
+		"upstream.cloudinary_base_url":  f.Upstream.CloudinaryBaseURL,
+		"upstream.cloudinary_asset_url": f.Upstream.CloudinaryAssetURL,
+		"upstream.s3_upload_url":        f.Upstream.S3UploadURL,
+	} {
+		if value == "" {
+			continue
+		}
+		u, err := url.Parse(value)
+		check(err == nil && u.Host != "" && (u.Scheme == "http" || u.Scheme == "https"), "%s %q must be an http or https URL", name, value)
+	}
+	if f.SessionMaxFailures != nil {
+		check(*f.SessionMaxFailures > 0, "session_max_failures must be positive")
+	}
+	for name, value := range map[string]*Duration{
+		"timeouts.upstream":               f.Timeouts.Upstream,
+		"timeouts.upstream_header":        f.Timeouts.UpstreamHeader,
+		"timeouts.session_cooldown":       f.Timeouts.SessionCooldown,
+		"timeouts.session_probe_interval": f.Timeouts.SessionProbeInterval,
+		"timeouts.shutdown":               f.Timeouts.Shutdown,
+		"timeouts.image_fetch":            f.Timeouts.ImageFetch,
+		"timeouts.attachment_cache":       f.Timeouts.AttachmentCache,
+	} {
+		if value != nil {
+			check(time.Duration(*value) >= time.Second, "%s must be at least 1s", name)
+		}
+	}
+	if f.Timeouts.ConfigWatchInterval != nil {
+		check(*f.Timeouts.ConfigWatchInterval >= 0, "timeouts.config_watch_interval must not be negative, 0 disables watching")
+	}
+	if f.ImageFetch.MaxMB != nil {
+		check(*f.ImageFetch.MaxMB > 0, "image_fetch.max_mb must be positive")
+	}
+	if f.ImageFetch.Proxy != "" {
+		u, err := url.Parse(f.ImageFetch.Proxy)
+		check(err == nil && u.Scheme != "" && u.Host != "", "image_fetch.proxy %q must be a proxy URL", logger.RedactURL(f.ImageFetch.Proxy))
+	}
+	if f.Image.MaxDimension != nil {
+		check(*f.Image.MaxDimension >= 0, "image.max_dimension must not be negative, 0 disables downscaling")
+	}
+	if f.Image.MaxPixels != nil {
+		check(*f.Image.MaxPixels > 0, "image.max_pixels must be positive")
+	}
+	if f.AttachmentCacheSize != nil {
+		check(*f.AttachmentCacheSize >= 0, "attachment_cache_size must not be negative, 0 disables the cache")
+	}
+	if f.Files.MaxMB != nil {
+		check(*f.Files.MaxMB > 0, "files.max_mb must be positive")
+	}
+	if f.RequestMaxMB != nil {
+		check(*f.RequestMaxMB > 0, "request_max_mb must be positive")
+	}
+	if f.RateLimit.RPM != nil {
+		check(*f.RateLimit.RPM >= 0, "rate_limit.rpm must not be negative")
+	}
+	if f.RateLimit.Streams != nil {
+		check(*f.RateLimit.Streams >= 0, "rate_limit.streams must not be negative")
+	}
+	if f.Log.Level != "" {
+		_, ok := logger.ParseLevel(f.Log.Level)
+		check(ok, "log.level %q must be debug, info, warn or error", f.Log.Level)
+	}
+	if f.Log.Format != "" {
+		check(f.Log.Format == logger.FormatText || f.Log.Format == logger.FormatJSON, "log.format %q must be text or json", f.Log.Format)
+	}
+	return errors.Join(errs...)
+}
+
+// env returns the file values keyed by the environment variable they correspond to
+func (f *FileConfig) env() map[string]string {
+	env := map[string]string{}
+	setString := func(key, value string) {
+		if value != "" {
+			env[key] = value
+		}
+	}
+	setBool := func(key string, value *bool) {
+		if value != nil {
+			env[key] = strconv.FormatBool(*value)
+		}
+	}
+	setInt := func(key string, value *int) {
+		if value != nil {
+			env[key] = strconv.Itoa(*value)
+		}
+	}
+	setDuration := func(key string, value *Duration) {
+		if value != nil {
+			env[key] = time.Duration(*value).String()
+		}
+	}
+	setString("ADDRESS", f.Address)
+	setString("APIKEY", f.APIKey)
+	setString("ADMIN_KEY", f.AdminKey)
+	setString("METRICS_KEY", f.MetricsKey)
+	setString("KEYS_FILE", f.KeysFile)
+	setString("PROXY", f.Proxy)
+	setString("SESSIONS", strings.Join(f.Sessions, ","))
+	setBool("IS_INCOGNITO", f.IsIncognito)
+	setInt("MAX_CHAT_HISTORY_LENGTH", f.MaxChatHistoryLength)
+	setBool("NO_ROLE_PREFIX", f.NoRolePrefix)
+	setString("PROMPT_FOR_FILE", f.PromptForFile)
+	setString("REASONING_MODE", f.ReasoningMode)
+	setBool("IGNORE_SEARCH_RESULT", f.Search.IgnoreSearchResult)
+	setBool("SEARCH_RESULT_COMPATIBLE", f.Search.SearchResultCompatible)
+	setBool("SEARCH_RESULT_MARKDOWN", f.Search.SearchResultMarkdown)
+	setBool("IGNORE_MODEL_MONITORING", f.Search.IgnoreModelMonitoring)
+	setString("PPLX_BASE_URL", f.Upstream.PplxBaseURL)
+	setString("CLOUDINARY_BASE_URL", f.Upstream.CloudinaryBaseURL)
+	setString("CLOUDINARY_ASSET_URL", f.Upstream.CloudinaryAssetURL)
+	setString("S3_UPLOAD_URL", f.Upstream.S3UploadURL)
+	setInt("SESSION_MAX_FAILURES", f.SessionMaxFailures)
+	setDuration("UPSTREAM_TIMEOUT", f.Timeouts.Upstream)
+	setDuration("UPSTREAM_HEADER_TIMEOUT", f.Timeouts.UpstreamHeader)
+	setDuration("SESSION_COOLDOWN", f.Timeouts.SessionCooldown)
+	setDuration("SESSION_PROBE_INTERVAL", f.Timeouts.SessionProbeInterval)
+	setDuration("SHUTDOWN_TIMEOUT", f.Timeouts.Shutdown)
+	setDuration("CONFIG_WATCH_INTERVAL", f.Timeouts.ConfigWatchInterval)
+	setDuration("IMAGE_FETCH_TIMEOUT", f.Timeouts.ImageFetch)
+	setInt("IMAGE_FETCH_MAX_MB", f.ImageFetch.MaxMB)
+	setString("IMAGE_FETCH_PROXY", f.ImageFetch.Proxy)
+	setBool("IMAGE_FETCH_ALLOW_PRIVATE", f.ImageFetch.AllowPrivate)
+	setBool("IMAGE_TRANSCODE", f.Image.Transcode)
+	setInt("IMAGE_MAX_DIMENSION", f.Image.MaxDimension)
+	setInt("IMAGE_MAX_PIXELS", f.Image.MaxPixels)
+	setInt("ATTACHMENT_CACHE_SIZE", f.AttachmentCacheSize)
+	setDuration("ATTACHMENT_CACHE_TTL", f.Timeouts.AttachmentCache)
+	setString("FILES_DIR", f.Files.Dir)
+	setInt("FILES_MAX_MB", f.Files.MaxMB)
+	setInt("REQUEST_MAX_MB", f.RequestMaxMB)
+	setInt("RATE_LIMIT_RPM", f.RateLimit.RPM)
+	setInt("RATE_LIMIT_STREAMS", f.RateLimit.Streams)
+	setString("LOG_LEVEL", f.Log.Level)
+	setString("LOG_FORMAT", f.Log.Format)
+	return env
+}
+
+// parseDuration reads a duration written as whole seconds ("30") or as a Go duration ("500ms", "2m")
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// mergedFileConfig reads the environment variables, with the file values as fallback, back into
+// a FileConfig so that Validate checks them the same way as the config file
+func mergedFileConfig(fileConfig *FileConfig) (*FileConfig, error) {
+	var errs []error
+	merged := &FileConfig{
+		APIKeys:  fileConfig.APIKeys,
+		ModelMap: fileConfig.ModelMap,
+	}
+	getBool := func(key string, target **bool) {
+		switch value := getEnv(key); value {
+		case "":
+		case "true", "false":
+			parsed := value == "true"
+			*target = &parsed
+		default:
+			errs = append(errs, fmt.Errorf("%s %q must be true or false", key, value))
+		}
+	}
+	getInt := func(key string, target **int) {
+		value := getEnv(key)
+		if value == "" {
+			return
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %q must be an integer", key, value))
+			return
+		}
+		*target = &parsed
+	}
+	getDuration := func(key string, target **Duration) {
+		value := getEnv(key)
+		if value == "" {
+			return
+		}
+		parsed, err := parseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %q must be a number of seconds or a duration such as 500ms or 2m", key, value))
+			return
+		}
+		duration := Duration(parsed)
+		*target = &duration
+	}
+	merged.Address = getEnv("ADDRESS")
+	merged.Proxy = getEnv("PROXY")
+	for _, session := range strings.Split(getEnv("SESSIONS"), ",") {
+		if session != "" {
+			merged.Sessions = append(merged.Sessions, session)
+		}
+	}
+	getBool("IS_INCOGNITO", &merged.IsIncognito)
+	getInt("MAX_CHAT_HISTORY_LENGTH", &merged.MaxChatHistoryLength)
+	getBool("NO_ROLE_PREFIX", &merged.NoRolePrefix)
+	merged.ReasoningMode = getEnv("REASONING_MODE")
+	getBool("IGNORE_SEARCH_RESULT", &merged.Search.IgnoreSearchResult)
+	getBool("SEARCH_RESULT_COMPATIBLE", &merged.Search.SearchResultCompatible)
+	getBool("SEARCH_RESULT_MARKDOWN", &merged.Search.SearchResultMarkdown)
+	getBool("IGNORE_MODEL_MONITORING", &merged.Search.IgnoreModelMonitoring)
+	merged.Upstream.PplxBaseURL = getEnv("PPLX_BASE_URL")
+	merged.Upstream.CloudinaryBaseURL = getEnv("CLOUDINARY_BASE_URL")
+	merged.Upstream.CloudinaryAssetURL = getEnv("CLOUDINARY_ASSET_URL")
+	merged.Upstream.S3UploadURL = getEnv("S3_UPLOAD_URL")
+	getInt("SESSION_MAX_FAILURES", &merged.SessionMaxFailures)
+	getDuration("UPSTREAM_TIMEOUT", &merged.Timeouts.Upstream)
+	getDuration("UPSTREAM_HEADER_TIMEOUT", &merged.Timeouts.UpstreamHeader)
+	getDuration("SESSION_COOLDOWN", &merged.Timeouts.SessionCooldown)
+	getDuration("SESSION_PROBE_INTERVAL", &merged.Timeouts.SessionProbeInterval)
+	getDuration("SHUTDOWN_TIMEOUT", &merged.Timeouts.Shutdown)
+	getDuration("CONFIG_WATCH_INTERVAL", &merged.Timeouts.ConfigWatchInterval)
+	getDuration("IMAGE_FETCH_TIMEOUT", &merged.Timeouts.ImageFetch)
+	getInt("IMAGE_FETCH_MAX_MB", &merged.ImageFetch.MaxMB)
+	merged.ImageFetch.Proxy = getEnv("IMAGE_FETCH_PROXY")
+	getBool("IMAGE_FETCH_ALLOW_PRIVATE", &merged.ImageFetch.AllowPrivate)
+	getBool("IMAGE_TRANSCODE", &merged.Image.Transcode)
+	getInt("IMAGE_MAX_DIMENSION", &merged.Image.MaxDimension)
+	getInt("IMAGE_MAX_PIXELS", &merged.Image.MaxPixels)
+	getInt("ATTACHMENT_CACHE_SIZE", &merged.AttachmentCacheSize)
+	getDuration("ATTACHMENT_CACHE_TTL", &merged.Timeouts.AttachmentCache)
+	getInt("FILES_MAX_MB", &merged.Files.MaxMB)
+	getInt("REQUEST_MAX_MB", &merged.RequestMaxMB)
+	getInt("RATE_LIMIT_RPM", &merged.RateLimit.RPM)
+	getInt("RATE_LIMIT_STREAMS", &merged.RateLimit.Streams)
+	merged.Log.Level = getEnv("LOG_LEVEL")
+	merged.Log.Format = strings.ToLower(getEnv("LOG_FORMAT"))
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return merged, merged.Validate()
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigRejectsInvalidValues(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("LOG_LEVEL", "ERROR")
+	tests := []struct {
+		env   string
+		value string
+		want  string
+	}{
+		{"RATE_LIMIT_RPM", "abc", `RATE_LIMIT_RPM "abc" must be an integer`},
+		{"RATE_LIMIT_RPM", "-1", "rate_limit.rpm must not be negative"},
+		{"REASONING_MODE", "loud", `reasoning_mode "loud" must be`},
+		{"IS_INCOGNITO", "yes", `IS_INCOGNITO "yes" must be true or false`},
+		{"LOG_FORMAT", "xml", `log.format "xml" must be text or json`},
+		{"SESSION_COOLDOWN", "500ms", "timeouts.session_cooldown must be at least 1s"},
+		{"UPSTREAM_TIMEOUT", "soon", `UPSTREAM_TIMEOUT "soon" must be a number of seconds`},
+		{"CONFIG_WATCH_INTERVAL", "-1s", "timeouts.config_watch_interval must not be negative"},
+		{"PPLX_BASE_URL", "localhost", `upstream.pplx_base_url "localhost" must be an http or https URL`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			_, err := loadConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDurations(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("LOG_LEVEL", "ERROR")
+	tests := []struct {
+		env   string
+		value string
+		get   func(*Config) time.Duration
+		want  time.Duration
+	}{
+		{"CONFIG_WATCH_INTERVAL", "500ms", func(c *Config) time.Duration { return c.ConfigWatchInterval }, 500 * time.Millisecond},
+		{"CONFIG_WATCH_INTERVAL", "0", func(c *Config) time.Duration { return c.ConfigWatchInterval }, 0},
+		{"SESSION_COOLDOWN", "90", func(c *Config) time.Duration { return c.SessionCooldown }, 90 * time.Second},
+		{"SESSION_COOLDOWN", "2m", func(c *Config) time.Duration { return c.SessionCooldown }, 2 * time.Minute},
+		{"UPSTREAM_HEADER_TIMEOUT", "1500ms", func(c *Config) time.Duration { return c.UpstreamHeaderTimeout }, 1500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			cfg, err := loadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.get(cfg); got != tt.want {
+				t.Errorf("%s = %s, want %s", tt.env, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileDurations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "timeouts:\n  config_watch_interval: 500ms\n  upstream_header: 2500ms\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("LOG_LEVEL", "ERROR")
+	t.Setenv("CONFIG_WATCH_INTERVAL", "")
+	t.Setenv("UPSTREAM_HEADER_TIMEOUT", "")
+	previousEnv := fileEnv
+	t.Cleanup(func() { fileEnv = previousEnv })
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ConfigWatchInterval != 500*time.Millisecond {
+		t.Errorf("ConfigWatchInterval = %s, want 500ms", cfg.ConfigWatchInterval)
+	}
+	if cfg.UpstreamHeaderTimeout != 2500*time.Millisecond {
+		t.Errorf("UpstreamHeaderTimeout = %s, want 2.5s", cfg.UpstreamHeaderTimeout)
+	}
+}
+
+func TestReloadKeepsConfigOnInvalidEnv(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("LOG_LEVEL", "ERROR")
+	previous := Current()
+	t.Cleanup(func() { current.Store(previous) })
+
+	t.Setenv("RATE_LIMIT_RPM", "abc")
+	if result := Reload("test"); result.Success {
+		t.Fatal("reload succeeded with RATE_LIMIT_RPM=abc")
+	}
+	if Current() != previous {
+		t.Error("failed reload replaced the current config")
+	}
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type SessionState string
+
+const (
+	// SessionHealthy 会话可以正常使用
+	SessionHealthy SessionState = "healthy"
+	// SessionRateLimited 会话被限流，冷却结束前不会被轮询到
+	SessionRateLimited SessionState = "rate_limited"
+	// SessionInvalid 会话多次鉴权失败，已退出轮询，等待探测恢复
+	SessionInvalid SessionState = "invalid"
+)
+
+// SessionHealth 记录单个会话的健康状态
+type SessionHealth struct {
+	State               SessionState `json:"state"`
+	CooldownUntil       time.Time    `json:"cooldown_until"`
+	LastError           string       `json:"last_error,omitempty"`
+	LastErrorAt         time.Time    `json:"last_error_at"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+}
+
+// HealthTracker 按 session key 跟踪会话健康状态
+type HealthTracker struct {
+	mu       sync.Mutex
+	sessions map[string]*SessionHealth
+}
+
+func NewHealthTracker() *HealthTracker {
+	return &HealthTracker{
+		sessions: map[string]*SessionHealth{},
+	}
+}
+
+// Health 全局会话健康状态
+var Health = NewHealthTracker()
+
+// entry 返回会话的状态记录，调用方需持有锁
+func (h *HealthTracker) entry(key string) *SessionHealth {
+	health, ok := h.sessions[key]
+	if !ok {
+		health = &SessionHealth{State: SessionHealthy}
+		h.sessions[key] = health
+	}
+	// 冷却结束后自动恢复
+	if health.State == SessionRateLimited && !time.Now().Before(health.CooldownUntil) {
+		health.State = SessionHealthy
+		health.CooldownUntil = time.Time{}
+	}
+	return health
+}
+
+// Get 返回会话当前的健康状态
+func (h *HealthTracker) Get(key string) SessionHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return *h.entry(key)
+}
+
+// Available 判断会话是否可以参与轮询
+func (h *HealthTracker) Available(key string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.entry(key).State == SessionHealthy
+}
+
+// ReportSuccess 记录一次成功请求
+func (h *HealthTracker) ReportSuccess(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health := h.entry(key)
+	health.State = SessionHealthy
+	health.CooldownUntil = time.Time{}
+	health.ConsecutiveFailures = 0
+}
+
+// ReportFailure 记录一次失败请求，statusCode 为上游返回的状态码，未知时为 0
+func (h *HealthTracker) ReportFailure(key string, statusCode int, err error) {
+	cfg := Current()
+	cooldown := cfg.SessionCooldown
+	maxFailures := cfg.SessionMaxFailures
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health := h.entry(key)
+	health.ConsecutiveFailures++
+	health.LastErrorAt = time.Now()
+	if err != nil {
+		health.LastError = err.Error()
+	} else {
+		health.LastError = fmt.Sprintf("status code %d", statusCode)
+	}
+	if health.State == SessionInvalid {
+		return
+	}
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		// 多次鉴权失败后退出轮询
+		if health.ConsecutiveFailures >= maxFailures {
+			health.State = SessionInvalid
+			health.CooldownUntil = time.Time{}
+		}
+	case statusCode == http.StatusTooManyRequests:
+		health.State = SessionRateLimited
+		health.CooldownUntil = time.Now().Add(cooldown)
+	default:
+		// 其他错误连续出现多次后短暂冷却
+		if health.ConsecutiveFailures >= maxFailures {
+			health.State = SessionRateLimited
+			health.CooldownUntil = time.Now().Add(cooldown)
+		}
+	}
+}
+
+// MarkHealthy 将会话恢复为可用状态，用于探测成功后
+func (h *HealthTracker) MarkHealthy(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	health := h.entry(key)
+	health.State = SessionHealthy
+	health.CooldownUntil = time.Time{}
+	health.ConsecutiveFailures = 0
+	health.LastError = ""
+}
+
+// Rename 会话刷新后 key 改变时，保留原有的健康状态
+func (h *HealthTracker) Rename(oldKey, newKey string) {
+	if oldKey == newKey {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if health, ok := h.sessions[oldKey]; ok {
+		h.sessions[newKey] = health
+		delete(h.sessions, oldKey)
+	}
+}
+
+// Remove 删除会话的健康状态
+func (h *HealthTracker) Remove(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions, key)
+}
+
+// Invalid 返回已退出轮询的会话
+func (h *HealthTracker) Invalid() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var keys []string
+	for key, health := range h.sessions {
+		if health.State == SessionInvalid {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// APIKeyInfo 描述一个 API 密钥及其限制
+type APIKeyInfo struct {
+	Key   string `json:"key" yaml:"key" toml:"key"`
+	Label string `json:"label" yaml:"label" toml:"label"`
+	// Models 允许使用的模型，为空时不限制，支持以 * 结尾的前缀匹配
+	Models []string `json:"models,omitempty" yaml:"models" toml:"models"`
+	// DailyRequests 每日请求数上限，0 表示不限制
+	DailyRequests int `json:"daily_requests,omitempty" yaml:"daily_requests" toml:"daily_requests"`
+	// DailyTokens 每日 token 上限，0 表示不限制
+	DailyTokens int        `json:"daily_tokens,omitempty" yaml:"daily_tokens" toml:"daily_tokens"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" yaml:"expires_at" toml:"expires_at"`
+}
+
+// KeysConfig 密钥文件的结构
+type KeysConfig struct {
+	Keys []APIKeyInfo `json:"keys"`
+}
+
+// AllowsModel 判断密钥是否可以使用该模型，-search 版本需要单独允许
+func (k APIKeyInfo) AllowsModel(model string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+	for _, allowed := range k.Models {
+		if allowed == model {
+			return true
+		}
+		if strings.HasSuffix(allowed, "*") && strings.HasPrefix(model, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired 判断密钥是否已过期
+func (k APIKeyInfo) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// LoadAPIKeys 从密钥文件加载 API 密钥，文件不存在时返回空列表
+func LoadAPIKeys(path string) ([]APIKeyInfo, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keysConfig KeysConfig
+	if err := json.Unmarshal(data, &keysConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse keys file %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i, key := range keysConfig.Keys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("key #%d in %s is empty", i, path)
+		}
+		if seen[key.Key] {
+			return nil, fmt.Errorf("key %q in %s is duplicated", key.Label, path)
+		}
+		seen[key.Key] = true
+	}
+	return keysConfig.Keys, nil
+}
+
+// FindAPIKey 查找密钥文件中的 API 密钥
+func (c *Config) FindAPIKey(key string) (APIKeyInfo, bool) {
+	for _, info := range c.APIKeys {
+		// 逐字节比较耗时固定，避免通过响应时间猜测密钥
+		if subtle.ConstantTimeCompare([]byte(info.Key), []byte(key)) == 1 {
+			return info, true
+		}
+	}
+	return APIKeyInfo{}, false
+}
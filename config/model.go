@@ -1,7 +1,12 @@
 package config
 
-var ModelReverseMap = map[string]string{}
-var ModelMap = map[string]string{
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultModelMap 内置的模型映射，可通过配置文件覆盖或补充
+var DefaultModelMap = map[string]string{
 	"claude-4.0-sonnet":       "claude2",
 	"claude-4.0-sonnet-think": "claude37sonnetthinking",
 	"deepseek-r1":             "r1",
@@ -16,9 +21,16 @@ var ModelMap = map[string]string{
 	"o3-pro":                "o3pro",
 }
 
+// modelMu 保护模型映射和模型列表
+var modelMu sync.RWMutex
+var ModelMap = map[string]string{}
+var ModelReverseMap = map[string]string{}
+
 // Get returns the value for the given key from the ModelMap.
 // If the key doesn't exist, it returns the provided default value.
 func ModelMapGet(key string, defaultValue string) string {
+	modelMu.RLock()
+	defer modelMu.RUnlock()
 	if value, exists := ModelMap[key]; exists {
 		return value
 	}
@@ -28,6 +40,8 @@ func ModelMapGet(key string, defaultValue string) string {
 // GetReverse returns the value for the given key from the ModelReverseMap.
 // If the key doesn't exist, it returns the provided default value.
 func ModelReverseMapGet(key string, defaultValue string) string {
+	modelMu.RLock()
+	defer modelMu.RUnlock()
 	if value, exists := ModelReverseMap[key]; exists {
 		return value
 	}
@@ -36,15 +50,35 @@ func ModelReverseMapGet(key string, defaultValue string) string {
 
 var ResponseModles []map[string]string
 
-func init() {
-	for k, v := range ModelMap {
-		ModelReverseMap[v] = k
+// GetResponseModels 返回 /v1/models 展示的模型列表
+func GetResponseModels() []map[string]string {
+	modelMu.RLock()
+	defer modelMu.RUnlock()
+	return ResponseModles
+}
+
+// SetModelMap 替换模型映射，并重建反向映射和模型列表
+func SetModelMap(modelMap map[string]string) {
+	names := make([]string, 0, len(modelMap))
+	reverseMap := make(map[string]string, len(modelMap))
+	for k, v := range modelMap {
+		names = append(names, k)
+		reverseMap[v] = k
+	}
+	sort.Strings(names)
+	responseModels := make([]map[string]string, 0, len(names)*2)
+	for _, k := range names {
 		model := map[string]string{
 			"id": k,
 		}
 		modelSearch := map[string]string{
 			"id": k + "-search",
 		}
-		ResponseModles = append(ResponseModles, model, modelSearch)
+		responseModels = append(responseModels, model, modelSearch)
 	}
+	modelMu.Lock()
+	defer modelMu.Unlock()
+	ModelMap = modelMap
+	ModelReverseMap = reverseMap
+	ResponseModles = responseModels
 }
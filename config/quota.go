@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRequestQuotaExceeded = errors.New("daily request quota exceeded")
+	ErrTokenQuotaExceeded   = errors.New("daily token quota exceeded")
+)
+
+// KeyUsage 记录密钥当天的用量
+type KeyUsage struct {
+	Requests int `json:"requests"`
+	Tokens   int `json:"tokens"`
+}
+
+// QuotaTracker 按天统计每个密钥的请求数和 token 用量，每天零点清零
+type QuotaTracker struct {
+	mu    sync.Mutex
+	day   string
+	usage map[string]*KeyUsage
+}
+
+func NewQuotaTracker() *QuotaTracker {
+	return &QuotaTracker{
+		usage: map[string]*KeyUsage{},
+	}
+}
+
+// Quotas 全局密钥用量
+var Quotas = NewQuotaTracker()
+
+// entry 返回密钥当天的用量记录，调用方需持有锁
+func (q *QuotaTracker) entry(key string) *KeyUsage {
+	day := time.Now().Format("2006-01-02")
+	if q.day != day {
+		q.day = day
+		q.usage = map[string]*KeyUsage{}
+	}
+	usage, ok := q.usage[key]
+	if !ok {
+		usage = &KeyUsage{}
+		q.usage[key] = usage
+	}
+	return usage
+}
+
+// Acquire 检查配额并记录一次请求
+func (q *QuotaTracker) Acquire(info APIKeyInfo) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage := q.entry(info.Key)
+	if info.DailyRequests > 0 && usage.Requests >= info.DailyRequests {
+		return ErrRequestQuotaExceeded
+	}
+	if info.DailyTokens > 0 && usage.Tokens >= info.DailyTokens {
+		return ErrTokenQuotaExceeded
+	}
+	usage.Requests++
+	return nil
+}
+
+// AddTokens 记录请求完成后的 token 用量
+func (q *QuotaTracker) AddTokens(key string, tokens int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entry(key).Tokens += tokens
+}
+
+// Usage 返回密钥当天的用量
+func (q *QuotaTracker) Usage(key string) KeyUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return *q.entry(key)
+}
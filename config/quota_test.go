@@ -0,0 +1,114 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaTrackerAcquire(t *testing.T) {
+	tests := []struct {
+		name string
+		info APIKeyInfo
+		// tokens are added after every accepted request
+		tokens   int
+		requests int
+		// wantErrAt is the request that fails first, 0 when every request is accepted
+		wantErrAt int
+		wantErr   error
+	}{
+		{"unlimited", APIKeyInfo{Key: "unlimited"}, 100, 5, 0, nil},
+		{"request quota", APIKeyInfo{Key: "requests", DailyRequests: 3}, 0, 5, 4, ErrRequestQuotaExceeded},
+		{"token quota", APIKeyInfo{Key: "tokens", DailyTokens: 250}, 100, 5, 4, ErrTokenQuotaExceeded},
+		{"request quota before token quota", APIKeyInfo{Key: "both", DailyRequests: 2, DailyTokens: 1000}, 100, 3, 3, ErrRequestQuotaExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQuotaTracker()
+			for i := 1; i <= tt.requests; i++ {
+				err := q.Acquire(tt.info)
+				if i == tt.wantErrAt {
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("request %d error = %v, want %v", i, err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("request %d error = %v", i, err)
+				}
+				q.AddTokens(tt.info.Key, tt.tokens)
+			}
+			if tt.wantErrAt != 0 {
+				t.Fatalf("request %d was accepted", tt.wantErrAt)
+			}
+			if got := q.Usage(tt.info.Key); got.Requests != tt.requests || got.Tokens != tt.requests*tt.tokens {
+				t.Errorf("usage = %+v, want %d requests and %d tokens", got, tt.requests, tt.requests*tt.tokens)
+			}
+		})
+	}
+}
+
+func TestQuotaTrackerKeysAreSeparate(t *testing.T) {
+	q := NewQuotaTracker()
+	a := APIKeyInfo{Key: "a", DailyRequests: 1}
+	b := APIKeyInfo{Key: "b", DailyRequests: 1}
+	if err := q.Acquire(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Acquire(b); err != nil {
+		t.Errorf("quota of b was used by a: %v", err)
+	}
+	if err := q.Acquire(a); !errors.Is(err, ErrRequestQuotaExceeded) {
+		t.Errorf("second request of a error = %v, want %v", err, ErrRequestQuotaExceeded)
+	}
+}
+
+func TestQuotaTrackerResetsDaily(t *testing.T) {
+	q := NewQuotaTracker()
+	info := APIKeyInfo{Key: "daily", DailyRequests: 1}
+	if err := q.Acquire(info); err != nil {
+		t.Fatal(err)
+	}
+	q.AddTokens(info.Key, 10)
+	// 模拟日期变化
+	q.mu.Lock()
+	q.day = "2000-01-01"
+	q.mu.Unlock()
+	if got := q.Usage(info.Key); got != (KeyUsage{}) {
+		t.Errorf("usage after midnight = %+v, want empty", got)
+	}
+	if err := q.Acquire(info); err != nil {
+		t.Errorf("request after midnight error = %v", err)
+	}
+}
+
+func TestAPIKeyInfo(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name        string
+		info        APIKeyInfo
+		model       string
+		wantAllowed bool
+		wantExpired bool
+	}{
+		{"no whitelist", APIKeyInfo{}, "o3", true, false},
+		{"exact match", APIKeyInfo{Models: []string{"o3"}}, "o3", true, false},
+		{"search needs its own entry", APIKeyInfo{Models: []string{"o3"}}, "o3-search", false, false},
+		{"prefix match", APIKeyInfo{Models: []string{"claude-*"}}, "claude-4.0-sonnet-search", true, false},
+		{"not listed", APIKeyInfo{Models: []string{"claude-*"}}, "gpt-4o", false, false},
+		{"expired", APIKeyInfo{ExpiresAt: &past}, "o3", true, true},
+		{"expires at now", APIKeyInfo{ExpiresAt: &now}, "o3", true, true},
+		{"not expired", APIKeyInfo{ExpiresAt: &future}, "o3", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.info.AllowsModel(tt.model); got != tt.wantAllowed {
+				t.Errorf("AllowsModel(%q) = %t, want %t", tt.model, got, tt.wantAllowed)
+			}
+			if got := tt.info.Expired(now); got != tt.wantExpired {
+				t.Errorf("Expired() = %t, want %t", got, tt.wantExpired)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"pplx2api/logger"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReloadResult 记录一次配置重新加载的结果
+type ReloadResult struct {
+	Time    time.Time `json:"time"`
+	Trigger string    `json:"trigger"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	Changes []string  `json:"changes,omitempty"`
+}
+
+// maxReloadResults 保留最近的重新加载结果数量
+const maxReloadResults = 20
+
+var (
+	reloadMu      sync.Mutex
+	reloadResults []ReloadResult
+	reloadHooks   []func(*Config)
+)
+
+// restartOnlyFields 修改后需要重启才能生效的配置，重新加载时保留当前值
+var restartOnlyFields = map[string]bool{
+	"Address":              true,
+	"SessionProbeInterval": true,
+	"ConfigWatchInterval":  true,
+}
+
+// OnReload 注册配置重新加载成功后的回调
+func OnReload(hook func(*Config)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadHooks = append(reloadHooks, hook)
+}
+
+// ReloadResults 返回最近的重新加载结果，最新的在前
+func ReloadResults() []ReloadResult {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	results := make([]ReloadResult, len(reloadResults))
+	for i, result := range reloadResults {
+		results[len(reloadResults)-1-i] = result
+	}
+	return results
+}
+
+// Reload 重新读取环境变量、配置文件和密钥文件，构建新的配置快照并原子替换当前配置和模型映射。
+// 加载失败时保留当前配置。
+func Reload(trigger string) ReloadResult {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	result := ReloadResult{Time: time.Now(), Trigger: trigger}
+	next, err := loadConfig()
+	if err != nil {
+		result.Error = err.Error()
+		logger.Error(fmt.Sprintf("Config reload (%s) failed, keeping current config: %v", trigger, err))
+	} else {
+		result.Success = true
+		previous := Current()
+		result.Changes = diff(previous, next)
+		// 新配置沿用当前的会话列表，再按环境变量和配置文件的变化增删会话
+		next.sessions = previous.sessions
+		result.Changes = append(next.syncSourceSessions(previous.sourceSessions), result.Changes...)
+		current.Store(next)
+		applyLogSettings(next)
+		SetModelMap(next.ModelMap)
+		for _, hook := range reloadHooks {
+			hook(next)
+		}
+		logger.Info(fmt.Sprintf("Config reloaded (%s), changed: %s", trigger, strings.Join(result.Changes, ", ")))
+	}
+	reloadResults = append(reloadResults, result)
+	if len(reloadResults) > maxReloadResults {
+		reloadResults = reloadResults[len(reloadResults)-maxReloadResults:]
+	}
+	return result
+}
+
+// diff 返回两份配置之间发生变化的配置名。需要重启才能生效的配置在 next 中恢复为当前值，
+// 使当前配置与实际运行的状态一致
+func diff(previous *Config, next *Config) []string {
+	var changes []string
+	old := reflect.ValueOf(previous).Elem()
+	updated := reflect.ValueOf(next).Elem()
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		if !field.IsExported() || reflect.DeepEqual(old.Field(i).Interface(), updated.Field(i).Interface()) {
+			continue
+		}
+		if restartOnlyFields[field.Name] {
+			updated.Field(i).Set(old.Field(i))
+			changes = append(changes, field.Name+" (restart required)")
+			continue
+		}
+		changes = append(changes, field.Name)
+	}
+	return changes
+}
+
+// syncSourceSessions 添加新出现在环境变量或配置文件中的会话，删除已被移除的会话，
+// 通过管理接口添加或刷新后的会话保持不变
+func (c *Config) syncSourceSessions(previous []SessionInfo) []string {
+	inPrevious := map[string]bool{}
+	for _, session := range previous {
+		inPrevious[session.SessionKey] = true
+	}
+	inNext := map[string]bool{}
+	added, removed := 0, 0
+	for _, session := range c.sourceSessions {
+		inNext[session.SessionKey] = true
+		if !inPrevious[session.SessionKey] && c.AddSession(session.SessionKey) == nil {
+			added++
+		}
+	}
+	for _, session := range previous {
+		if !inNext[session.SessionKey] && c.RemoveSession(session.SessionKey) == nil {
+			removed++
+		}
+	}
+	if added == 0 && removed == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("Sessions (+%d -%d)", added, removed)}
+}
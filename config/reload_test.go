@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"pplx2api/logger"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestReloadPublishesConsistentSnapshots(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	previous := Current()
+	t.Cleanup(func() { current.Store(previous) })
+
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cfg := Current()
+				// 同一个快照中的两个值总是一起修改
+				if want := fmt.Sprintf("prompt %d", cfg.MaxChatHistoryLength); cfg.MaxChatHistoryLength != 10000 && cfg.PromptForFile != want {
+					select {
+					case errs <- fmt.Errorf("half applied config: %d %q", cfg.MaxChatHistoryLength, cfg.PromptForFile):
+					default:
+					}
+				}
+				logger.Info("reader %d", cfg.MaxChatHistoryLength)
+			}
+		}()
+	}
+	for i := 1; i <= 50; i++ {
+		t.Setenv("MAX_CHAT_HISTORY_LENGTH", strconv.Itoa(i))
+		t.Setenv("PROMPT_FOR_FILE", fmt.Sprintf("prompt %d", i))
+		t.Setenv("LOG_LEVEL", []string{"WARN", "ERROR"}[i%2])
+		if result := Reload("test"); !result.Success {
+			t.Fatalf("reload failed: %s", result.Error)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	default:
+	}
+}
+
+func TestReloadKeepsRestartOnlyFields(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("LOG_LEVEL", "ERROR")
+	previous := Current()
+	t.Cleanup(func() { current.Store(previous) })
+
+	tests := []struct {
+		env   string
+		value string
+		field string
+		get   func(*Config) string
+	}{
+		{"ADDRESS", "127.0.0.1:9999", "Address", func(c *Config) string { return c.Address }},
+		{"SESSION_PROBE_INTERVAL", "7", "SessionProbeInterval", func(c *Config) string { return c.SessionProbeInterval.String() }},
+		{"CONFIG_WATCH_INTERVAL", "9", "ConfigWatchInterval", func(c *Config) string { return c.ConfigWatchInterval.String() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			before := tt.get(Current())
+			t.Setenv(tt.env, tt.value)
+			result := Reload("test")
+			if !result.Success {
+				t.Fatalf("reload failed: %s", result.Error)
+			}
+			if got := tt.get(Current()); got != before {
+				t.Errorf("%s = %s after reload, want unchanged %s", tt.field, got, before)
+			}
+			found := false
+			for _, change := range result.Changes {
+				found = found || change == tt.field+" (restart required)"
+			}
+			if !found {
+				t.Errorf("changes %v do not report %s as restart required", result.Changes, tt.field)
+			}
+		})
+	}
+}
+
+func TestReloadKeepsRuntimeSessions(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("LOG_LEVEL", "ERROR")
+	t.Setenv("SESSIONS", "")
+	previous := Current()
+	t.Cleanup(func() { current.Store(previous) })
+	Reload("test")
+
+	if err := Current().AddSession("admin-session"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SESSIONS", "env-session")
+	Reload("test")
+	got := map[string]bool{}
+	for _, session := range Current().SessionsSnapshot() {
+		got[session.SessionKey] = true
+	}
+	if !got["admin-session"] || !got["env-session"] {
+		t.Errorf("sessions after reload = %v, want admin-session and env-session", got)
+	}
+	t.Setenv("SESSIONS", "")
+	Reload("test")
+	if Current().SessionCount() != 1 {
+		t.Errorf("sessions after removing env-session = %v", Current().SessionsSnapshot())
+	}
+}
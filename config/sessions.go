@@ -0,0 +1,203 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// sessionStore 保存运行时可修改的会话列表，管理接口、会话刷新和配置重新加载都在这里增删会话
+type sessionStore struct {
+	mu       sync.RWMutex
+	sessions []SessionInfo
+	// next 轮询的起始位置
+	next int
+}
+
+func newSessionStore(sessions []SessionInfo) *sessionStore {
+	store := &sessionStore{}
+	for _, session := range sessions {
+		session.id = nextSessionID()
+		store.sessions = append(store.sessions, session)
+	}
+	return store
+}
+
+var (
+	lastSessionID atomic.Uint64
+
+	sessionHooksMu      sync.Mutex
+	sessionRemovedHooks []func(uint64)
+)
+
+func nextSessionID() uint64 {
+	return lastSessionID.Add(1)
+}
+
+// OnSessionRemoved 注册会话被删除后的回调，参数为会话的固定编号。
+// 会话刷新 key 不算删除，编号保持不变
+func OnSessionRemoved(hook func(id uint64)) {
+	sessionHooksMu.Lock()
+	defer sessionHooksMu.Unlock()
+	sessionRemovedHooks = append(sessionRemovedHooks, hook)
+}
+
+func sessionRemoved(id uint64) {
+	sessionHooksMu.Lock()
+	hooks := append([]func(uint64){}, sessionRemovedHooks...)
+	sessionHooksMu.Unlock()
+	for _, hook := range hooks {
+		hook(id)
+	}
+}
+
+// SessionID 返回 session 的短标识，用于在管理接口中引用 session 而不暴露 key
+func SessionID(sessionKey string) string {
+	sum := sha256.Sum256([]byte(sessionKey))
+	return hex.EncodeToString(sum[:6])
+}
+
+// SessionsSnapshot 返回当前 session 列表的副本
+func (c *Config) SessionsSnapshot() []SessionInfo {
+	c.sessions.mu.RLock()
+	defer c.sessions.mu.RUnlock()
+	sessions := make([]SessionInfo, len(c.sessions.sessions))
+	copy(sessions, c.sessions.sessions)
+	return sessions
+}
+
+// NextSession 轮询下一个可用的 session，跳过已禁用、冷却中和已失效的 session。
+// 选择和读取在同一次加锁中完成，并发增删 session 不会使返回的 session 错位。
+// 返回 session 及其位置，没有可用 session 时返回 false
+func (c *Config) NextSession() (SessionInfo, int, bool) {
+	store := c.sessions
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	count := len(store.sessions)
+	for i := 0; i < count; i++ {
+		index := (store.next + i) % count
+		session := store.sessions[index]
+		if !session.Disabled && Health.Available(session.SessionKey) {
+			store.next = (index + 1) % count
+			return session, index, true
+		}
+	}
+	return SessionInfo{}, -1, false
+}
+
+// SessionCount 返回会话数量，也是一个请求最多尝试的次数
+func (c *Config) SessionCount() int {
+	c.sessions.mu.RLock()
+	defer c.sessions.mu.RUnlock()
+	return len(c.sessions.sessions)
+}
+
+// SetSessions 替换全部会话，用于从会话文件加载。
+// 仍然存在的 key 沿用原来的编号，不再存在的会话按删除处理
+func (c *Config) SetSessions(sessions []SessionInfo) {
+	c.sessions.mu.Lock()
+	removed := map[string]uint64{}
+	for _, session := range c.sessions.sessions {
+		removed[session.SessionKey] = session.id
+	}
+	next := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		if id, ok := removed[session.SessionKey]; ok {
+			session.id = id
+			delete(removed, session.SessionKey)
+		} else {
+			session.id = nextSessionID()
+		}
+		next = append(next, session)
+	}
+	c.sessions.sessions = next
+	c.sessions.mu.Unlock()
+	for _, id := range removed {
+		sessionRemoved(id)
+	}
+}
+
+// SessionIdentity 返回 session key 对应会话的固定编号，key 不属于任何会话时返回 false
+func (c *Config) SessionIdentity(sessionKey string) (uint64, bool) {
+	c.sessions.mu.RLock()
+	defer c.sessions.mu.RUnlock()
+	for _, session := range c.sessions.sessions {
+		if session.SessionKey == sessionKey {
+			return session.id, true
+		}
+	}
+	return 0, false
+}
+
+// FindSession 根据短标识查找 session
+func (c *Config) FindSession(id string) (SessionInfo, bool) {
+	c.sessions.mu.RLock()
+	defer c.sessions.mu.RUnlock()
+	for _, session := range c.sessions.sessions {
+		if SessionID(session.SessionKey) == id {
+			return session, true
+		}
+	}
+	return SessionInfo{}, false
+}
+
+// AddSession 添加一个新的 session
+func (c *Config) AddSession(sessionKey string) error {
+	if sessionKey == "" {
+		return fmt.Errorf("session key is empty")
+	}
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+	for _, session := range c.sessions.sessions {
+		if session.SessionKey == sessionKey {
+			return fmt.Errorf("session already exists")
+		}
+	}
+	c.sessions.sessions = append(c.sessions.sessions, SessionInfo{SessionKey: sessionKey, id: nextSessionID()})
+	return nil
+}
+
+// RemoveSession 删除 session
+func (c *Config) RemoveSession(sessionKey string) error {
+	c.sessions.mu.Lock()
+	for i, session := range c.sessions.sessions {
+		if session.SessionKey == sessionKey {
+			c.sessions.sessions = append(c.sessions.sessions[:i:i], c.sessions.sessions[i+1:]...)
+			c.sessions.mu.Unlock()
+			Health.Remove(sessionKey)
+			sessionRemoved(session.id)
+			return nil
+		}
+	}
+	c.sessions.mu.Unlock()
+	return fmt.Errorf("session not found")
+}
+
+// SetSessionDisabled 禁用或启用 session，禁用的 session 不参与轮询
+func (c *Config) SetSessionDisabled(sessionKey string, disabled bool) error {
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+	for i, session := range c.sessions.sessions {
+		if session.SessionKey == sessionKey {
+			c.sessions.sessions[i].Disabled = disabled
+			return nil
+		}
+	}
+	return fmt.Errorf("session not found")
+}
+
+// ReplaceSessionKey 会话刷新后替换 key，保留编号、其他属性和健康状态
+func (c *Config) ReplaceSessionKey(oldKey, newKey string) error {
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+	for i, session := range c.sessions.sessions {
+		if session.SessionKey == oldKey {
+			c.sessions.sessions[i].SessionKey = newKey
+			Health.Rename(oldKey, newKey)
+			return nil
+		}
+	}
+	return fmt.Errorf("session not found")
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// withSessions publishes a config holding sessions for the duration of the test
+func withSessions(t *testing.T, keys ...string) *Config {
+	t.Helper()
+	previous := Current()
+	t.Cleanup(func() { current.Store(previous) })
+	cfg := previous.Clone()
+	var sessions []SessionInfo
+	for _, key := range keys {
+		sessions = append(sessions, SessionInfo{SessionKey: key})
+	}
+	cfg.sessions = newSessionStore(sessions)
+	current.Store(cfg)
+	return cfg
+}
+
+func TestNextSessionSkipsUnavailableSessions(t *testing.T) {
+	cfg := withSessions(t, "rr-a", "rr-b", "rr-c", "rr-d")
+	if err := cfg.SetSessionDisabled("rr-b", true); err != nil {
+		t.Fatal(err)
+	}
+	Health.ReportFailure("rr-c", 429, fmt.Errorf("rate limited"))
+	t.Cleanup(func() { Health.Remove("rr-c") })
+
+	want := []string{"rr-a", "rr-d", "rr-a", "rr-d"}
+	for i, key := range want {
+		session, _, ok := cfg.NextSession()
+		if !ok || session.SessionKey != key {
+			t.Fatalf("pick %d = %q, %t, want %q", i, session.SessionKey, ok, key)
+		}
+	}
+}
+
+func TestNextSessionWithoutSessions(t *testing.T) {
+	cfg := withSessions(t)
+	if _, index, ok := cfg.NextSession(); ok || index != -1 {
+		t.Errorf("NextSession() = %d, %t, want -1, false", index, ok)
+	}
+}
+
+func TestNextSessionDuringConcurrentRemoval(t *testing.T) {
+	var keys []string
+	for i := 0; i < 50; i++ {
+		keys = append(keys, fmt.Sprintf("concurrent-%d", i))
+	}
+	cfg := withSessions(t, keys...)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, key := range keys[:40] {
+			cfg.RemoveSession(key)
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		session, index, ok := cfg.NextSession()
+		if !ok {
+			t.Fatal("no session available")
+		}
+		if session.SessionKey == "" {
+			t.Fatalf("pick %d returned an empty session at %d", i, index)
+		}
+	}
+	wg.Wait()
+	if got := cfg.SessionCount(); got != 10 {
+		t.Errorf("SessionCount() = %d, want 10", got)
+	}
+}
+
+func TestSessionIdentity(t *testing.T) {
+	cfg := withSessions(t, "id-a", "id-b")
+	var mu sync.Mutex
+	var removed []uint64
+	OnSessionRemoved(func(id uint64) {
+		mu.Lock()
+		defer mu.Unlock()
+		removed = append(removed, id)
+	})
+	identity := func(key string) uint64 {
+		t.Helper()
+		id, ok := cfg.SessionIdentity(key)
+		if !ok {
+			t.Fatalf("no identity for %s", key)
+		}
+		return id
+	}
+	a, b := identity("id-a"), identity("id-b")
+	if a == b {
+		t.Fatalf("sessions share identity %d", a)
+	}
+
+	if err := cfg.ReplaceSessionKey("id-a", "id-a-refreshed"); err != nil {
+		t.Fatal(err)
+	}
+	if got := identity("id-a-refreshed"); got != a {
+		t.Errorf("identity after refresh = %d, want %d", got, a)
+	}
+	if _, ok := cfg.SessionIdentity("id-a"); ok {
+		t.Error("the replaced key still has an identity")
+	}
+
+	if err := cfg.RemoveSession("id-b"); err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetSessions([]SessionInfo{{SessionKey: "id-a-refreshed"}, {SessionKey: "id-b"}})
+	if got := identity("id-a-refreshed"); got != a {
+		t.Errorf("identity after SetSessions = %d, want %d", got, a)
+	}
+	readded := identity("id-b")
+	if readded == b {
+		t.Error("a removed session's identity was reused")
+	}
+	cfg.SetSessions(nil)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(removed) != 3 || removed[0] != b || removed[1]+removed[2] != a+readded {
+		t.Errorf("removed = %v, want %d, then %d and %d", removed, b, a, readded)
+	}
+}
@@ -3,17 +3,21 @@ package core
 import (
 	"bufio"
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"pplx2api/config"
 	"pplx2api/logger"
+	"pplx2api/metrics"
 	"pplx2api/model"
 	"pplx2api/utils"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -28,6 +32,27 @@ type Client struct {
 	Model        string
 	Attachments  []string
 	OpenSerch    bool
+	endpoints    Endpoints
+	cfg          *config.Config
+	log          *logger.Entry
+}
+
+// Endpoints holds the upstream URLs used by the client
+type Endpoints struct {
+	BaseURL            string
+	CloudinaryBaseURL  string
+	CloudinaryAssetURL string
+	S3UploadURL        string
+}
+
+// EndpointsFromConfig returns the upstream URLs configured in cfg
+func EndpointsFromConfig(cfg *config.Config) Endpoints {
+	return Endpoints{
+		BaseURL:            cfg.PplxBaseURL,
+		CloudinaryBaseURL:  cfg.CloudinaryBaseURL,
+		CloudinaryAssetURL: cfg.CloudinaryAssetURL,
+		S3UploadURL:        cfg.S3UploadURL,
+	}
 }
 
 // Perplexity API structures
@@ -112,22 +137,30 @@ type ImageModeBlock struct {
 	} `json:"media_items"`
 }
 
-// NewClient creates a new Perplexity API client
-func NewClient(sessionToken string, proxy string, model string, openSerch bool) *Client {
-	client := req.C().ImpersonateChrome().SetTimeout(time.Minute * 10)
-	client.Transport.SetResponseHeaderTimeout(time.Second * 10)
-	if proxy != "" {
-		client.SetProxyURL(proxy)
+// NewClient creates a new Perplexity API client, the client reads every setting from cfg
+func NewClient(cfg *config.Config, sessionToken string, model string, openSerch bool) *Client {
+	endpoints := EndpointsFromConfig(cfg)
+	timeout, headerTimeout := time.Minute*10, time.Second*10
+	if cfg.UpstreamTimeout > 0 {
+		timeout = cfg.UpstreamTimeout
+	}
+	if cfg.UpstreamHeaderTimeout > 0 {
+		headerTimeout = cfg.UpstreamHeaderTimeout
+	}
+	client := req.C().ImpersonateChrome().SetTimeout(timeout)
+	client.Transport.SetResponseHeaderTimeout(headerTimeout)
+	if cfg.Proxy != "" {
+		client.SetProxyURL(cfg.Proxy)
 	}
 
 	// Set common headers
 	headers := map[string]string{
 		"accept-language": "en-US,en;q=0.9,zh-CN;q=0.8,zh;q=0.7,zh-TW;q=0.6",
 		"cache-control":   "no-cache",
-		"origin":          "https://www.perplexity.ai",
+		"origin":          endpoints.BaseURL,
 		"pragma":          "no-cache",
 		"priority":        "u=1, i",
-		"referer":         "https://www.perplexity.ai/",
+		"referer":         endpoints.BaseURL + "/",
 	}
 
 	for key, value := range headers {
@@ -149,13 +182,26 @@ func NewClient(sessionToken string, proxy string, model string, openSerch bool)
 		Model:        model,
 		Attachments:  []string{},
 		OpenSerch:    openSerch,
+		endpoints:    endpoints,
+		cfg:          cfg,
+		log:          logger.WithRequestID(""),
 	}
 
 	return c
 }
 
+// SetRequestID tags the logs of the client with the id of the request it serves
+func (c *Client) SetRequestID(requestID string) {
+	c.log = logger.WithRequestID(requestID)
+}
+
 // SendMessage sends a message to Perplexity and returns the status and response
-func (c *Client) SendMessage(message string, stream bool, is_incognito bool, gc *gin.Context) (int, error) {
+func (c *Client) SendMessage(message string, is_incognito bool, out model.Renderer, gc *gin.Context) (int, error) {
+	start := time.Now()
+	defer func() {
+		metrics.UpstreamLatency.WithLabelValues(metrics.ModelLabel(c.Model)).Observe(time.Since(start).Seconds())
+	}()
+	out = &firstTokenRenderer{Renderer: out, start: start, model: c.Model}
 	// Create request body
 	requestBody := PerplexityRequest{
 		Params: PerplexityParams{
@@ -205,55 +251,53 @@ func (c *Client) SendMessage(message string, stream bool, is_incognito bool, gc
 		requestBody.Params.SearchFocus = "internet"
 		requestBody.Params.Sources = append(requestBody.Params.Sources, "web")
 	}
-	logger.Info(fmt.Sprintf("Perplexity request body: %v", requestBody))
+	// 日志中隐藏用户提示词
+	loggedBody := requestBody
+	loggedBody.QueryStr = logger.Prompt(requestBody.QueryStr)
+	c.log.Info("Perplexity request body: %v", loggedBody)
 	// Make the request
 	resp, err := c.client.R().DisableAutoReadResponse().
 		SetBody(requestBody).
-		Post("https://www.perplexity.ai/rest/sse/perplexity_ask")
+		Post(c.endpoints.BaseURL + "/rest/sse/perplexity_ask")
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("Error sending request: %v", err))
+		c.log.Error(fmt.Sprintf("Error sending request: %v", err))
 		return 500, fmt.Errorf("request failed: %w", err)
 	}
 
-	logger.Info(fmt.Sprintf("Perplexity response status code: %d", resp.StatusCode))
+	c.log.Info(fmt.Sprintf("Perplexity response status code: %d", resp.StatusCode))
 
 	if resp.StatusCode == http.StatusTooManyRequests {
 		resp.Body.Close()
-		return http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded")
+		return http.StatusTooManyRequests, &UpstreamError{StatusCode: http.StatusTooManyRequests, Message: "rate limit exceeded"}
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("Unexpected return data: %s", resp.String()))
+		c.log.Error(fmt.Sprintf("Unexpected return data: %s", resp.String()))
 		resp.Body.Close()
-		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return resp.StatusCode, &UpstreamError{StatusCode: resp.StatusCode}
 	}
 
-	return 200, c.HandleResponse(resp.Body, stream, gc)
+	return 200, c.HandleResponse(resp.Body, out, gc)
 }
 
-func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context) error {
+func (c *Client) HandleResponse(body io.ReadCloser, out model.Renderer, gc *gin.Context) error {
 	defer body.Close()
 	// Set headers for streaming
-	if stream {
-		gc.Writer.Header().Set("Content-Type", "text/event-stream")
-		gc.Writer.Header().Set("Cache-Control", "no-cache")
-		gc.Writer.Header().Set("Connection", "keep-alive")
-		gc.Writer.WriteHeader(http.StatusOK)
-		gc.Writer.Flush()
+	if err := out.Start(); err != nil {
+		return err
 	}
 	scanner := bufio.NewScanner(body)
 	clientDone := gc.Request.Context().Done()
 	// 增大缓冲区大小
 	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
-	full_text := ""
-	inThinking := false
-	thinkShown := false
 	final := false
+	// complete 表示回答已达到停止序列或 max_tokens，不再读取剩余的上游数据
+	complete := false
 	for scanner.Scan() {
 		select {
 		case <-clientDone:
-			logger.Info("Client connection closed")
+			c.log.Info("Client connection closed")
 			return nil
 		default:
 		}
@@ -270,7 +314,7 @@ func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context
 		// logger.Info(fmt.Sprintf("Received data: %s", data))
 		var response PerplexityResponse
 		if err := json.Unmarshal([]byte(data), &response); err != nil {
-			logger.Error(fmt.Sprintf("Error parsing JSON: %v", err))
+			c.log.Error(fmt.Sprintf("Error parsing JSON: %v", err))
 			continue
 		}
 		// Check for completion and web results
@@ -288,38 +332,47 @@ func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context
 					if len(imageModelList) > 0 {
 						imageResultsText = imageResultsText + "\n\n---\n" + strings.Join(imageModelList, ", ")
 					}
-					full_text += imageResultsText
-
-					if stream {
-						model.ReturnOpenAIResponse(imageResultsText, stream, gc)
-					}
+					out.Text(imageResultsText)
 				}
 			}
 			for _, block := range response.Blocks {
-				if !config.ConfigInstance.IgnoreSerchResult && block.WebResultBlock != nil && len(block.WebResultBlock.WebResults) > 0 {
+				if !c.cfg.IgnoreSerchResult && block.WebResultBlock != nil && len(block.WebResultBlock.WebResults) > 0 {
+					searchResults := []model.SearchResult{}
+					for _, result := range block.WebResultBlock.WebResults {
+						searchResults = append(searchResults, model.SearchResult{
+							Title:   result.Name,
+							URL:     result.URL,
+							Snippet: result.Snippet,
+						})
+					}
+					out.SearchResults(searchResults)
+					if !c.cfg.SearchResultMarkdown {
+						continue
+					}
 					webResultsText := "\n\n---\n"
 					for i, result := range block.WebResultBlock.WebResults {
-						webResultsText += "\n\n" + utils.SearchShow(i, result.Name, result.URL, result.Snippet)
-					}
-					full_text += webResultsText
-
-					if stream {
-						model.ReturnOpenAIResponse(webResultsText, stream, gc)
+						webResultsText += "\n\n" + utils.SearchShow(c.cfg, i, result.Name, result.URL, result.Snippet)
 					}
+					out.Text(webResultsText)
 				}
 
 			}
 
-			if !config.ConfigInstance.IgnoreModelMonitoring && response.DisplayModel != c.Model {
+			if response.DisplayModel != "" && response.DisplayModel != c.Model {
+				out.DisplayModel(config.ModelReverseMapGet(response.DisplayModel, response.DisplayModel))
+			}
+			if !c.cfg.IgnoreModelMonitoring && response.DisplayModel != c.Model {
 				res_text := "\n\n---\n"
 				res_text += fmt.Sprintf("Display Model: %s\n", config.ModelReverseMapGet(response.DisplayModel, response.DisplayModel))
-				full_text += res_text
-				if !stream {
-					break
+				if errors.Is(out.Text(res_text), model.ErrAnswerComplete) {
+					complete = true
 				}
-				model.ReturnOpenAIResponse(res_text, stream, gc)
 			}
 		}
+		if complete {
+			c.log.Info("Answer complete, closing the upstream response early")
+			break
+		}
 		if final {
 			break
 		}
@@ -327,45 +380,34 @@ func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context
 		for _, block := range response.Blocks {
 			// Handle reasoning plan blocks (thinking)
 			if block.ReasoningPlanBlock != nil && len(block.ReasoningPlanBlock.Goals) > 0 {
-
 				res_text := ""
-				if !inThinking && !thinkShown {
-					res_text += "<think>"
-					inThinking = true
-				}
-
 				for _, goal := range block.ReasoningPlanBlock.Goals {
 					if goal.Description != "" && goal.Description != "Beginning analysis" && goal.Description != "Wrapping up analysis" {
 						res_text += goal.Description
 					}
 				}
-				full_text += res_text
-				if !stream {
-					continue
+				if errors.Is(out.Thinking(res_text), model.ErrAnswerComplete) {
+					complete = true
 				}
-				model.ReturnOpenAIResponse(res_text, stream, gc)
 			}
 		}
 		for _, block := range response.Blocks {
 			if block.MarkdownBlock != nil && len(block.MarkdownBlock.Chunks) > 0 {
 				res_text := ""
-				if inThinking {
-					res_text += "</think>\n\n"
-					inThinking = false
-					thinkShown = true
-				}
 				for _, chunk := range block.MarkdownBlock.Chunks {
 					if chunk != "" {
 						res_text += chunk
 					}
 				}
-				full_text += res_text
-				if !stream {
-					continue
+				if errors.Is(out.Text(res_text), model.ErrAnswerComplete) {
+					complete = true
 				}
-				model.ReturnOpenAIResponse(res_text, stream, gc)
 			}
 		}
+		if complete {
+			c.log.Info("Answer complete, closing the upstream response early")
+			break
+		}
 
 	}
 
@@ -373,15 +415,7 @@ func (c *Client) HandleResponse(body io.ReadCloser, stream bool, gc *gin.Context
 		return fmt.Errorf("error reading response: %w", err)
 	}
 
-	if !stream {
-		model.ReturnOpenAIResponse(full_text, stream, gc)
-	} else {
-		// Send end marker for streaming mode
-		gc.Writer.Write([]byte("data: [DONE]\n\n"))
-		gc.Writer.Flush()
-	}
-
-	return nil
+	return out.Finish()
 }
 
 // UploadURLResponse represents the response from the create_upload_url endpoint
@@ -412,69 +446,185 @@ type CloudinaryUploadInfo struct {
 	ACL               string `json:"acl"`
 }
 
+// uploadSecretFields are the upload credentials that are redacted before logging, compared in lower case
+var uploadSecretFields = map[string]bool{
+	"policy":               true,
+	"signature":            true,
+	"x-amz-security-token": true,
+	"awsaccesskeyid":       true,
+	"api_key":              true,
+}
+
+// redactUploadBody returns a JSON upload response with its credentials redacted,
+// other bodies are reduced to their length
+func redactUploadBody(body []byte) string {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Sprintf("[%d bytes]", len(body))
+	}
+	data, err := json.Marshal(redactUploadValue(value))
+	if err != nil {
+		return fmt.Sprintf("[%d bytes]", len(body))
+	}
+	return string(data)
+}
+
+func redactUploadValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if uploadSecretFields[strings.ToLower(key)] {
+				v[key] = "[redacted]"
+			} else {
+				v[key] = redactUploadValue(field)
+			}
+		}
+	case []interface{}:
+		for i, field := range v {
+			v[i] = redactUploadValue(field)
+		}
+	}
+	return value
+}
+
 // UploadFile is a placeholder for file upload functionality
-func (c *Client) createUploadURL(filename string, contentType string) (*UploadURLResponse, error) {
+func (c *Client) createUploadURL(filename string, contentType string, fileSize int) (*UploadURLResponse, error) {
 	requestBody := map[string]interface{}{
 		"filename":     filename,
 		"content_type": contentType,
 		"source":       "default",
-		"file_size":    12000,
+		"file_size":    fileSize,
 		"force_image":  false,
 	}
 	resp, err := c.client.R().
 		SetBody(requestBody).
-		Post("https://www.perplexity.ai/rest/uploads/create_upload_url?version=2.18&source=default")
+		Post(c.endpoints.BaseURL + "/rest/uploads/create_upload_url?version=2.18&source=default")
 	if err != nil {
-		logger.Error(fmt.Sprintf("Error creating upload URL: %v", err))
+		c.log.Error(fmt.Sprintf("Error creating upload URL: %v", err))
 		return nil, err
 	}
+	// 响应中包含 S3 policy、签名和临时凭证，只在 DEBUG 级别输出脱敏后的内容
+	c.log.Debug("Create upload URL response: %s", redactUploadBody(resp.Bytes()))
 	if resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("Image Upload with status code %d: %s", resp.StatusCode, resp.String()))
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		c.log.Error(fmt.Sprintf("Create upload URL failed with status code %d", resp.StatusCode))
+		return nil, &UpstreamError{StatusCode: resp.StatusCode}
 	}
 	var uploadURLResponse UploadURLResponse
-	logger.Info(fmt.Sprintf("Create upload with status code %d: %s", resp.StatusCode, resp.String()))
 	if err := json.Unmarshal(resp.Bytes(), &uploadURLResponse); err != nil {
-		logger.Error(fmt.Sprintf("Error unmarshalling upload URL response: %v", err))
+		c.log.Error(fmt.Sprintf("Error unmarshalling upload URL response: %v", err))
 		return nil, err
 	}
 	if uploadURLResponse.RateLimited {
-		logger.Error("Rate limit exceeded for upload URL")
-		return nil, fmt.Errorf("rate limit exceeded")
+		c.log.Error("Rate limit exceeded for upload URL")
+		return nil, &UpstreamError{StatusCode: http.StatusTooManyRequests, Message: "rate limit exceeded"}
 	}
 	return &uploadURLResponse, nil
 
 }
 
+// uploadConcurrency 同时上传的附件数量上限
+const uploadConcurrency = 4
+
 func (c *Client) UploadImage(img_list []string) error {
-	logger.Info(fmt.Sprintf("Uploading %d images to Cloudinary", len(img_list)))
+	c.log.Info(fmt.Sprintf("Uploading %d images to Cloudinary", len(img_list)))
 
-	// Upload images to Cloudinary
+	// 同一请求中重复的图片只上传一次
+	unique := make([]string, 0, len(img_list))
+	seen := make(map[string]bool, len(img_list))
 	for _, img := range img_list {
-		filename := utils.RandomString(5) + ".jpg"
-		// Create upload URL
-		uploadURLResponse, err := c.createUploadURL(filename, "image/jpeg")
-		if err != nil {
-			logger.Error(fmt.Sprintf("Error creating upload URL: %v", err))
-			return err
+		if !seen[img] {
+			seen[img] = true
+			unique = append(unique, img)
 		}
-		logger.Info(fmt.Sprintf("Upload URL response: %v", uploadURLResponse))
-		// Upload image to Cloudinary
-		err = c.UloadFileToCloudinary(uploadURLResponse.Fields, "img", img, filename)
+	}
+	// Upload images to Cloudinary
+	urls, err := uploadAll(len(unique), func(i int) (string, error) {
+		return c.uploadImage(unique[i])
+	})
+	if err != nil {
+		return err
+	}
+	c.Attachments = append(c.Attachments, urls...)
+	return nil
+}
+
+// uploadAll runs upload for n attachments concurrently and returns their URLs in order
+func uploadAll(n int, upload func(i int) (string, error)) ([]string, error) {
+	urls := make([]string, n)
+	errs := make([]error, n)
+	sem := make(chan struct{}, uploadConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			urls[i], errs[i] = upload(i)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
 		if err != nil {
-			logger.Error(fmt.Sprintf("Error uploading image: %v", err))
-			return err
+			return nil, err
 		}
 	}
-	return nil
+	return urls, nil
 }
 
-func (c *Client) UloadFileToCloudinary(uploadInfo CloudinaryUploadInfo, contentType string, filedata string, filename string) error {
-	if len(filedata) > 100 {
-		logger.Info(fmt.Sprintf("filedata: %s ……", filedata[:50]))
+// uploadImage uploads one image and returns its attachment URL, reusing the URL
+// of the same processed image uploaded before with this session
+func (c *Client) uploadImage(img string) (string, error) {
+	file, err := prepareImage(c.cfg, img)
+	if err != nil {
+		metrics.UploadFailures.WithLabelValues("image").Inc()
+		c.log.Error(fmt.Sprintf("Error preparing image: %v", err))
+		return "", &InputError{Message: err.Error()}
+	}
+	upload := attachmentUpload{Data: file.Data, ContentType: file.ContentType, Options: imageOptions(c.cfg)}
+	if url, ok := c.cachedUpload("image", upload); ok {
+		c.log.Info(fmt.Sprintf("Reusing uploaded image %s", url))
+		return url, nil
 	}
+	// Create upload URL
+	uploadURLResponse, err := c.createUploadURL(file.Filename, file.ContentType, len(file.Data))
+	if err != nil {
+		metrics.UploadFailures.WithLabelValues("image").Inc()
+		c.log.Error(fmt.Sprintf("Error creating upload URL: %v", err))
+		return "", err
+	}
+	// Upload image to Cloudinary
+	url, err := c.UloadFileToCloudinary(uploadURLResponse.Fields, "img", file.Data, file.Filename, file.ContentType)
+	if err != nil {
+		metrics.UploadFailures.WithLabelValues("image").Inc()
+		c.log.Error(fmt.Sprintf("Error uploading image: %v", err))
+		return "", err
+	}
+	c.rememberUpload(upload, url)
+	return url, nil
+}
+
+// cachedUpload returns the attachment URL of an upload made before with this session.
+// Sessions that are not in the session list are not cached
+func (c *Client) cachedUpload(kind string, upload attachmentUpload) (string, bool) {
+	session, ok := c.cfg.SessionIdentity(c.sessionToken)
+	if !ok {
+		return "", false
+	}
+	return Attachments.Get(kind, session, upload)
+}
+
+// rememberUpload records the attachment URL of an upload made with this session
+func (c *Client) rememberUpload(upload attachmentUpload, url string) {
+	if session, ok := c.cfg.SessionIdentity(c.sessionToken); ok {
+		Attachments.Put(session, upload, url)
+	}
+}
+
+// UloadFileToCloudinary uploads a file to Cloudinary or S3 and returns its attachment URL
+func (c *Client) UloadFileToCloudinary(uploadInfo CloudinaryUploadInfo, contentType string, filedata []byte, filename string, mimeType string) (string, error) {
 	// Add form fields
-	logger.Info(fmt.Sprintf("Uploading file %s to Cloudinary", filename))
+	c.log.Info(fmt.Sprintf("Uploading file %s (%s, %d bytes) to Cloudinary", filename, mimeType, len(filedata)))
 	var formFields map[string]string
 	if contentType == "img" {
 		formFields = map[string]string{
@@ -494,7 +644,7 @@ func (c *Client) UloadFileToCloudinary(uploadInfo CloudinaryUploadInfo, contentT
 	} else {
 		formFields = map[string]string{
 			"acl":                  uploadInfo.ACL,
-			"Content-Type":         "text/plain",
+			"Content-Type":         mimeType,
 			"tagging":              uploadInfo.Tagging,
 			"key":                  uploadInfo.Key,
 			"AWSAccessKeyId":       uploadInfo.AWSAccessKeyId,
@@ -507,42 +657,39 @@ func (c *Client) UloadFileToCloudinary(uploadInfo CloudinaryUploadInfo, contentT
 	writer := multipart.NewWriter(&requestBody)
 	for key, value := range formFields {
 		if err := writer.WriteField(key, value); err != nil {
-			logger.Error(fmt.Sprintf("Error writing form field %s: %v", key, err))
-			return err
+			c.log.Error(fmt.Sprintf("Error writing form field %s: %v", key, err))
+			return "", err
 		}
 	}
 
-	// Add the file,filedata 是base64编码的字符串
-	decodedData, err := base64.StdEncoding.DecodeString(filedata)
-	if err != nil {
-		logger.Error(fmt.Sprintf("Error decoding base64 data: %v", err))
-		return err
-	}
-
-	// 创建一个文件部分
-	part, err := writer.CreateFormFile("file", filename) // 替换 filename.ext 为实际文件名
+	// 创建一个文件部分，使用文件的实际类型
+	header := make(textproto.MIMEHeader)
+	// 文件名来自客户端，由 mime 转义引号、反斜杠和控制字符，避免破坏或注入表单内容
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": filename}))
+	header.Set("Content-Type", mimeType)
+	part, err := writer.CreatePart(header)
 	if err != nil {
-		logger.Error(fmt.Sprintf("Error creating form file: %v", err))
-		return err
+		c.log.Error(fmt.Sprintf("Error creating form file: %v", err))
+		return "", err
 	}
 
-	// 将解码后的数据写入文件部分
-	if _, err := part.Write(decodedData); err != nil {
-		logger.Error(fmt.Sprintf("Error writing file data: %v", err))
-		return err
+	// 将文件数据写入文件部分
+	if _, err := part.Write(filedata); err != nil {
+		c.log.Error(fmt.Sprintf("Error writing file data: %v", err))
+		return "", err
 	}
 	// Close the writer to finalize the form
 	if err := writer.Close(); err != nil {
-		logger.Error(fmt.Sprintf("Error closing writer: %v", err))
-		return err
+		c.log.Error(fmt.Sprintf("Error closing writer: %v", err))
+		return "", err
 	}
 
 	// Create the upload request
 	var uploadURL string
 	if contentType == "img" {
-		uploadURL = fmt.Sprintf("https://api.cloudinary.com/v1_1/%s/image/upload", uploadInfo.CloudName)
+		uploadURL = fmt.Sprintf("%s/v1_1/%s/image/upload", c.endpoints.CloudinaryBaseURL, uploadInfo.CloudName)
 	} else {
-		uploadURL = "https://ppl-ai-file-upload.s3.amazonaws.com/"
+		uploadURL = c.endpoints.S3UploadURL
 	}
 
 	resp, err := c.client.R().
@@ -551,55 +698,105 @@ func (c *Client) UloadFileToCloudinary(uploadInfo CloudinaryUploadInfo, contentT
 		Post(uploadURL)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("Error uploading file: %v", err))
-		return err
+		c.log.Error(fmt.Sprintf("Error uploading file: %v", err))
+		return "", err
+	}
+	c.log.Info(fmt.Sprintf("Upload finished with status code %d", resp.StatusCode))
+	c.log.Debug("Upload response: %s", redactUploadBody(resp.Bytes()))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", &UpstreamError{StatusCode: resp.StatusCode}
 	}
-	logger.Info(fmt.Sprintf("Image Upload with status code %d: %s", resp.StatusCode, resp.String()))
 	if contentType == "img" {
 		var uploadResponse map[string]interface{}
 		if err := json.Unmarshal(resp.Bytes(), &uploadResponse); err != nil {
-			return err
+			return "", err
 		}
-		imgUrl := uploadResponse["secure_url"].(string)
-		imgUrl = "https://pplx-res.cloudinary.com/image/private" + imgUrl[strings.Index(imgUrl, "/user_uploads"):]
-		c.Attachments = append(c.Attachments, imgUrl)
-	} else {
-		c.Attachments = append(c.Attachments, "https://ppl-ai-file-upload.s3.amazonaws.com/"+uploadInfo.Key)
+		imgUrl, _ := uploadResponse["secure_url"].(string)
+		if !strings.Contains(imgUrl, "/user_uploads") {
+			return "", fmt.Errorf("unexpected upload response: %s", redactUploadBody(resp.Bytes()))
+		}
+		return c.endpoints.CloudinaryAssetURL + imgUrl[strings.Index(imgUrl, "/user_uploads"):], nil
 	}
+	return c.endpoints.S3UploadURL + uploadInfo.Key, nil
+}
+
+// UploadFiles uploads documents to S3 as attachments
+func (c *Client) UploadFiles(files []Document) error {
+	c.log.Info(fmt.Sprintf("Uploading %d files", len(files)))
+	urls, err := uploadAll(len(files), func(i int) (string, error) {
+		return c.uploadFile(files[i])
+	})
+	if err != nil {
+		return err
+	}
+	c.Attachments = append(c.Attachments, urls...)
 	return nil
 }
 
+// uploadFile uploads one document and returns its attachment URL, reusing the URL
+// of the same document uploaded before with this session
+func (c *Client) uploadFile(file Document) (string, error) {
+	upload := attachmentUpload{Data: file.Data, ContentType: file.ContentType, Filename: file.Filename}
+	if url, ok := c.cachedUpload("file", upload); ok {
+		c.log.Info(fmt.Sprintf("Reusing uploaded file %s", url))
+		return url, nil
+	}
+	uploadURLResponse, err := c.createUploadURL(file.Filename, file.ContentType, len(file.Data))
+	if err != nil {
+		metrics.UploadFailures.WithLabelValues("file").Inc()
+		c.log.Error(fmt.Sprintf("Error creating upload URL: %v", err))
+		return "", err
+	}
+	url, err := c.UloadFileToCloudinary(uploadURLResponse.Fields, "file", file.Data, file.Filename, file.ContentType)
+	if err != nil {
+		metrics.UploadFailures.WithLabelValues("file").Inc()
+		c.log.Error(fmt.Sprintf("Error uploading file: %v", err))
+		return "", err
+	}
+	c.rememberUpload(upload, url)
+	return url, nil
+}
+
 // SetBigContext is a placeholder for setting context
 func (c *Client) UploadText(context string) error {
-	logger.Info("Uploading txt to Cloudinary")
-	filedata := base64.StdEncoding.EncodeToString([]byte(context))
+	c.log.Info("Uploading txt to Cloudinary")
+	filedata := []byte(context)
+	upload := attachmentUpload{Data: filedata, ContentType: "text/plain"}
+	if url, ok := c.cachedUpload("text", upload); ok {
+		c.log.Info(fmt.Sprintf("Reusing uploaded text %s", url))
+		c.Attachments = append(c.Attachments, url)
+		return nil
+	}
 	filename := utils.RandomString(5) + ".txt"
 	// Upload images to Cloudinary
-	uploadURLResponse, err := c.createUploadURL(filename, "text/plain")
+	uploadURLResponse, err := c.createUploadURL(filename, "text/plain", len(filedata))
 	if err != nil {
-		logger.Error(fmt.Sprintf("Error creating upload URL: %v", err))
+		metrics.UploadFailures.WithLabelValues("text").Inc()
+		c.log.Error(fmt.Sprintf("Error creating upload URL: %v", err))
 		return err
 	}
-	logger.Info(fmt.Sprintf("Upload URL response: %v", uploadURLResponse))
 	// Upload txt to Cloudinary
-	err = c.UloadFileToCloudinary(uploadURLResponse.Fields, "txt", filedata, filename)
+	url, err := c.UloadFileToCloudinary(uploadURLResponse.Fields, "txt", filedata, filename, "text/plain")
 	if err != nil {
-		logger.Error(fmt.Sprintf("Error uploading image: %v", err))
+		metrics.UploadFailures.WithLabelValues("text").Inc()
+		c.log.Error(fmt.Sprintf("Error uploading image: %v", err))
 		return err
 	}
+	c.rememberUpload(upload, url)
+	c.Attachments = append(c.Attachments, url)
 
 	return nil
 }
 
 func (c *Client) GetNewCookie() (string, error) {
-	resp, err := c.client.R().Get("https://www.perplexity.ai/api/auth/session")
+	resp, err := c.client.R().Get(c.endpoints.BaseURL + "/api/auth/session")
 	if err != nil {
-		logger.Error(fmt.Sprintf("Error getting session cookie: %v", err))
+		c.log.Error(fmt.Sprintf("Error getting session cookie: %v", err))
 		return "", err
 	}
 	if resp.StatusCode != http.StatusOK {
-		logger.Error(fmt.Sprintf("Error getting session cookie: %s", resp.String()))
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		c.log.Error(fmt.Sprintf("Error getting session cookie: %s", resp.String()))
+		return "", &UpstreamError{StatusCode: resp.StatusCode}
 	}
 	for _, cookie := range resp.Cookies() {
 		if cookie.Name == "__Secure-next-auth.session-token" {
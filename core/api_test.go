@@ -0,0 +1,110 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"pplx2api/config"
+	"strings"
+	"testing"
+)
+
+func TestUploadEscapesFilename(t *testing.T) {
+	type received struct {
+		filename string
+		fields   []string
+		files    int
+	}
+	var got received
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = received{}
+		for key := range r.MultipartForm.Value {
+			got.fields = append(got.fields, key)
+		}
+		for _, headers := range r.MultipartForm.File {
+			got.files += len(headers)
+			got.filename = headers[0].Filename
+		}
+		json.NewEncoder(w).Encode(map[string]string{"secure_url": "https://res/image/private/user_uploads/x.png"})
+	}))
+	defer srv.Close()
+	cfg := config.Current().Clone()
+	cfg.Proxy = ""
+	cfg.CloudinaryBaseURL = srv.URL
+	cfg.S3UploadURL = srv.URL + "/"
+	c := NewClient(cfg, "", "", false)
+
+	tests := []struct {
+		name        string
+		contentType string
+		filename    string
+		wantFields  int
+	}{
+		{"quote and backslash", "img", `a"b\\c.png`, 12},
+		{"header injection", "img", "x.png\"\r\nContent-Type: text/html\r\n\r\n<script>", 12},
+		{"part injection", "file", "x.txt\"\r\n\r\n--boundary\r\nContent-Disposition: form-data; name=\"policy\"\r\n\r\nforged", 8},
+		{"unicode", "file", "报告.txt", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.UloadFileToCloudinary(CloudinaryUploadInfo{Key: "k"}, tt.contentType, []byte("data"), tt.filename, "text/plain"); err != nil {
+				t.Fatal(err)
+			}
+			// multipart 解析文件名时只保留最后一个 / 之后的部分
+			if want := path.Base(tt.filename); got.files != 1 || got.filename != want {
+				t.Errorf("received %d files named %q, want one named %q", got.files, got.filename, want)
+			}
+			if len(got.fields) != tt.wantFields {
+				t.Errorf("received fields %v, want %d fields", got.fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestRedactUploadBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "create upload URL response",
+			body:    `{"s3_bucket_url":"https://bucket","fields":{"key":"uploads/a.txt","policy":"cG9saWN5","signature":"c2ln","x-amz-security-token":"dG9rZW4=","AWSAccessKeyId":"ASIAEXAMPLE","api_key":"123456"}}`,
+			want:    []string{`"key":"uploads/a.txt"`, `"s3_bucket_url":"https://bucket"`, `"policy":"[redacted]"`},
+			notWant: []string{"cG9saWN5", "c2ln", "dG9rZW4=", "ASIAEXAMPLE", "123456"},
+		},
+		{
+			name:    "cloudinary response",
+			body:    `{"secure_url":"https://res/user_uploads/a.png","signature":"abcdef","eager":[{"signature":"nested"}]}`,
+			want:    []string{`"secure_url":"https://res/user_uploads/a.png"`},
+			notWant: []string{"abcdef", "nested"},
+		},
+		{
+			name:    "non JSON body",
+			body:    `<Error><Code>AccessDenied</Code><Policy>cG9saWN5</Policy></Error>`,
+			want:    []string{"[65 bytes]"},
+			notWant: []string{"cG9saWN5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactUploadBody([]byte(tt.body))
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("redactUploadBody() = %s, want it to contain %s", got, want)
+				}
+			}
+			for _, secret := range tt.notWant {
+				if strings.Contains(got, secret) {
+					t.Errorf("redactUploadBody() = %s, leaks %s", got, secret)
+				}
+			}
+		})
+	}
+}
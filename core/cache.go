@@ -0,0 +1,130 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"pplx2api/config"
+	"pplx2api/metrics"
+	"sync"
+	"time"
+)
+
+// AttachmentCache remembers the URLs of uploaded attachments by session and content hash,
+// so repeated images and contexts are not uploaded again. Sessions are identified by
+// config.SessionIdentity, which survives cookie refreshes
+type AttachmentCache struct {
+	mu      sync.Mutex
+	entries map[string]attachmentEntry
+}
+
+type attachmentEntry struct {
+	session uint64
+	url     string
+	expires time.Time
+}
+
+// Attachments 全局附件缓存
+var Attachments = &AttachmentCache{entries: map[string]attachmentEntry{}}
+
+func init() {
+	// 会话删除后，它上传的附件不能再被其他会话使用
+	config.OnSessionRemoved(Attachments.Forget)
+}
+
+// attachmentUpload is what is sent to the upload target, the cache key is derived from it
+type attachmentUpload struct {
+	Data        []byte
+	ContentType string
+	// Filename is left empty when it is random, it is part of the key otherwise
+	Filename string
+	// Options are the processing options that produced Data, e.g. image transcoding and downscaling
+	Options string
+}
+
+// imageOptions describes how prepareImage processes images with cfg
+func imageOptions(cfg *config.Config) string {
+	return fmt.Sprintf("transcode=%t max_dimension=%d max_pixels=%d", cfg.ImageTranscode, cfg.ImageMaxDimension, cfg.ImageMaxPixels)
+}
+
+// attachmentKey combines the session identity with a hash of the uploaded bytes and their metadata,
+// the cache does not hold the bytes
+func attachmentKey(session uint64, upload attachmentUpload) string {
+	h := sha256.New()
+	for _, field := range []string{upload.ContentType, upload.Filename, upload.Options} {
+		// 以长度为前缀，避免字段拼接产生歧义
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	h.Write(upload.Data)
+	return fmt.Sprintf("%d:%s", session, hex.EncodeToString(h.Sum(nil)))
+}
+
+// Get returns the attachment URL of an upload made with session
+func (a *AttachmentCache) Get(kind string, session uint64, upload attachmentUpload) (string, bool) {
+	if config.Current().AttachmentCacheSize <= 0 {
+		return "", false
+	}
+	key := attachmentKey(session, upload)
+	a.mu.Lock()
+	entry, ok := a.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(a.entries, key)
+		ok = false
+	}
+	a.mu.Unlock()
+	if ok {
+		metrics.AttachmentCacheLookups.WithLabelValues(kind, "hit").Inc()
+		return entry.url, true
+	}
+	metrics.AttachmentCacheLookups.WithLabelValues(kind, "miss").Inc()
+	return "", false
+}
+
+// Put records the attachment URL of an upload made with session
+func (a *AttachmentCache) Put(session uint64, upload attachmentUpload, url string) {
+	cfg := config.Current()
+	size := cfg.AttachmentCacheSize
+	if size <= 0 {
+		return
+	}
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.entries) >= size {
+		a.evict(now, size)
+	}
+	a.entries[attachmentKey(session, upload)] = attachmentEntry{
+		session: session,
+		url:     url,
+		expires: now.Add(cfg.AttachmentCacheTTL),
+	}
+}
+
+// Forget removes the entries of a session
+func (a *AttachmentCache) Forget(session uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, entry := range a.entries {
+		if entry.session == session {
+			delete(a.entries, key)
+		}
+	}
+}
+
+// evict removes the expired entries, then the entries closest to expiry until there is room
+func (a *AttachmentCache) evict(now time.Time, size int) {
+	for key, entry := range a.entries {
+		if now.After(entry.expires) {
+			delete(a.entries, key)
+		}
+	}
+	for len(a.entries) >= size {
+		oldestKey, oldest := "", time.Time{}
+		for key, entry := range a.entries {
+			if oldestKey == "" || entry.expires.Before(oldest) {
+				oldestKey, oldest = key, entry.expires
+			}
+		}
+		delete(a.entries, oldestKey)
+	}
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"encoding/base64"
+	"pplx2api/config"
+	"testing"
+	"time"
+)
+
+func TestAttachmentKey(t *testing.T) {
+	png := pngImage(t, 8, 8)
+	prepared := func(value string, transcode bool, maxDimension int) attachmentUpload {
+		t.Helper()
+		cfg := config.Current().Clone()
+		cfg.ImageTranscode = transcode
+		cfg.ImageMaxDimension = maxDimension
+		file, err := prepareImage(cfg, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return attachmentUpload{Data: file.Data, ContentType: file.ContentType, Options: imageOptions(cfg)}
+	}
+	plain := base64.StdEncoding.EncodeToString(png)
+	base := prepared(plain, true, 0)
+	tests := []struct {
+		name     string
+		session  uint64
+		upload   attachmentUpload
+		wantSame bool
+	}{
+		{"same image as a data URL", 1, prepared("data:image/png;base64,"+plain, true, 0), true},
+		{"same image with a wrong declared type", 1, prepared("data:image/jpeg;base64,"+plain, true, 0), true},
+		{"another session", 2, base, false},
+		{"transcoding disabled", 1, prepared(plain, false, 0), false},
+		{"downscaling enabled", 1, prepared(plain, true, 4), false},
+		{"another content type", 1, attachmentUpload{Data: base.Data, ContentType: "image/jpeg", Options: base.Options}, false},
+		{"no field ambiguity", 1, attachmentUpload{Data: base.Data, ContentType: base.ContentType + base.Options}, false},
+	}
+	want := attachmentKey(1, base)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attachmentKey(tt.session, tt.upload); (got == want) != tt.wantSame {
+				t.Errorf("key = %s, base key = %s, want same %t", got, want, tt.wantSame)
+			}
+		})
+	}
+}
+
+// withCache publishes a config with the given cache limits and returns an empty cache
+func withCache(t *testing.T, size int, ttl time.Duration) *AttachmentCache {
+	t.Helper()
+	previous := config.Current()
+	t.Cleanup(func() { config.Store(previous) })
+	cfg := previous.Clone()
+	cfg.AttachmentCacheSize = size
+	cfg.AttachmentCacheTTL = ttl
+	config.Store(cfg)
+	return &AttachmentCache{entries: map[string]attachmentEntry{}}
+}
+
+func textUpload(text string) attachmentUpload {
+	return attachmentUpload{Data: []byte(text), ContentType: "text/plain"}
+}
+
+func TestAttachmentCacheTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		// age is how long ago the entry was stored
+		age  time.Duration
+		want bool
+	}{
+		{"fresh", 0, true},
+		{"before expiry", 59 * time.Minute, true},
+		{"expired", 61 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := withCache(t, 10, time.Hour)
+			cache.Put(1, textUpload("context"), "https://example.com/context.txt")
+			key := attachmentKey(1, textUpload("context"))
+			entry := cache.entries[key]
+			entry.expires = entry.expires.Add(-tt.age)
+			cache.entries[key] = entry
+
+			url, ok := cache.Get("text", 1, textUpload("context"))
+			if ok != tt.want {
+				t.Fatalf("Get() = %q, %t, want %t", url, ok, tt.want)
+			}
+			if ok && url != "https://example.com/context.txt" {
+				t.Errorf("Get() = %q", url)
+			}
+			if _, stored := cache.entries[key]; stored != tt.want {
+				t.Errorf("entry stored = %t after Get, want %t", stored, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachmentCacheEviction(t *testing.T) {
+	cache := withCache(t, 3, time.Hour)
+	for _, name := range []string{"a", "b", "c"} {
+		cache.Put(1, textUpload(name), "url-"+name)
+	}
+	// b 最先过期，c 已经过期
+	now := time.Now()
+	for name, expires := range map[string]time.Time{"a": now.Add(30 * time.Minute), "b": now.Add(10 * time.Minute), "c": now.Add(-time.Minute)} {
+		key := attachmentKey(1, textUpload(name))
+		cache.entries[key] = attachmentEntry{url: "url-" + name, expires: expires}
+	}
+
+	steps := []struct {
+		put  string
+		want []string
+		gone []string
+	}{
+		// 先清除过期的 c
+		{"d", []string{"a", "b", "d"}, []string{"c"}},
+		// 再淘汰最早过期的 b
+		{"e", []string{"a", "d", "e"}, []string{"b", "c"}},
+	}
+	for _, step := range steps {
+		cache.Put(1, textUpload(step.put), "url-"+step.put)
+		if len(cache.entries) != len(step.want) {
+			t.Errorf("after putting %s the cache holds %d entries, want %d", step.put, len(cache.entries), len(step.want))
+		}
+		for _, name := range step.want {
+			if url, ok := cache.Get("text", 1, textUpload(name)); !ok || url != "url-"+name {
+				t.Errorf("after putting %s: Get(%s) = %q, %t", step.put, name, url, ok)
+			}
+		}
+		for _, name := range step.gone {
+			if _, ok := cache.Get("text", 1, textUpload(name)); ok {
+				t.Errorf("after putting %s: %s is still cached", step.put, name)
+			}
+		}
+	}
+}
+
+func TestAttachmentCacheDisabled(t *testing.T) {
+	cache := withCache(t, 0, time.Hour)
+	cache.Put(1, textUpload("context"), "url")
+	if _, ok := cache.Get("text", 1, textUpload("context")); ok {
+		t.Error("disabled cache returned an entry")
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("disabled cache holds %d entries", len(cache.entries))
+	}
+}
+
+func TestAttachmentCacheSessionsAreSeparate(t *testing.T) {
+	cache := withCache(t, 10, time.Hour)
+	cache.Put(1, textUpload("context"), "url-a")
+	if _, ok := cache.Get("text", 2, textUpload("context")); ok {
+		t.Error("upload of session a was reused by session b")
+	}
+}
+
+func TestAttachmentCacheFollowsSessions(t *testing.T) {
+	withCache(t, 10, time.Hour)
+	cfg := config.Current()
+	previous := cfg.SessionsSnapshot()
+	t.Cleanup(func() { cfg.SetSessions(previous) })
+	cfg.SetSessions([]config.SessionInfo{{SessionKey: "cache-a"}, {SessionKey: "cache-b"}})
+	client := func(key string) *Client { return NewClient(cfg, key, "", false) }
+	sessionB, _ := cfg.SessionIdentity("cache-b")
+	client("cache-a").rememberUpload(textUpload("context"), "url-a")
+	client("cache-b").rememberUpload(textUpload("context"), "url-b")
+	client("not-a-session").rememberUpload(textUpload("context"), "url-unknown")
+
+	// 刷新 cookie 后仍然使用原来的缓存，旧 key 不再对应任何会话
+	if err := cfg.ReplaceSessionKey("cache-a", "cache-a-refreshed"); err != nil {
+		t.Fatal(err)
+	}
+	if url, ok := client("cache-a-refreshed").cachedUpload("text", textUpload("context")); !ok || url != "url-a" {
+		t.Errorf("after a refresh: cachedUpload() = %q, %t, want url-a", url, ok)
+	}
+	if url, ok := client("cache-a").cachedUpload("text", textUpload("context")); ok {
+		t.Errorf("the old key still gets %q", url)
+	}
+	if url, ok := client("not-a-session").cachedUpload("text", textUpload("context")); ok {
+		t.Errorf("a key outside the session list got %q", url)
+	}
+
+	if err := cfg.RemoveSession("cache-b"); err != nil {
+		t.Fatal(err)
+	}
+	sessionA, _ := cfg.SessionIdentity("cache-a-refreshed")
+	cfg.SetSessions([]config.SessionInfo{{SessionKey: "cache-c"}})
+	for _, entry := range Attachments.entries {
+		if entry.session == sessionA || entry.session == sessionB {
+			t.Errorf("entry %q of a removed session is still cached", entry.url)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path"
+	"pplx2api/utils"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFilenameBytes limits the length of the filenames sent by clients
+const maxFilenameBytes = 200
+
+// Document is a file attachment such as a PDF, DOCX, CSV or source file
+type Document struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// documentTypes maps file extensions to the content type of the upload,
+// source code and other plain text formats are uploaded as text/plain
+var documentTypes = map[string]string{
+	".pdf":   "application/pdf",
+	".doc":   "application/msword",
+	".docx":  "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":   "application/vnd.ms-excel",
+	".xlsx":  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ppt":   "application/vnd.ms-powerpoint",
+	".pptx":  "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":   "application/vnd.oasis.opendocument.text",
+	".rtf":   "application/rtf",
+	".epub":  "application/epub+zip",
+	".csv":   "text/csv",
+	".tsv":   "text/tab-separated-values",
+	".md":    "text/markdown",
+	".html":  "text/html",
+	".htm":   "text/html",
+	".xml":   "application/xml",
+	".json":  "application/json",
+	".txt":   "text/plain",
+	".log":   "text/plain",
+	".yaml":  "text/plain",
+	".yml":   "text/plain",
+	".toml":  "text/plain",
+	".ini":   "text/plain",
+	".sql":   "text/plain",
+	".sh":    "text/plain",
+	".go":    "text/plain",
+	".py":    "text/plain",
+	".js":    "text/plain",
+	".ts":    "text/plain",
+	".jsx":   "text/plain",
+	".tsx":   "text/plain",
+	".java":  "text/plain",
+	".kt":    "text/plain",
+	".c":     "text/plain",
+	".h":     "text/plain",
+	".cpp":   "text/plain",
+	".hpp":   "text/plain",
+	".cs":    "text/plain",
+	".rs":    "text/plain",
+	".rb":    "text/plain",
+	".php":   "text/plain",
+	".swift": "text/plain",
+	".css":   "text/plain",
+	".vue":   "text/plain",
+}
+
+// NewDocument decodes a data URL or plain base64 file and determines its content type
+// from the data URL, the file extension or the file content
+func NewDocument(filename string, value string) (*Document, error) {
+	declared := ""
+	if strings.HasPrefix(value, "data:") {
+		header, data, ok := strings.Cut(value, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid data URL")
+		}
+		declared = strings.ToLower(strings.SplitN(strings.TrimPrefix(header, "data:"), ";", 2)[0])
+		value = data
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		if data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "=")); err != nil {
+			return nil, fmt.Errorf("invalid base64 file data: %w", err)
+		}
+	}
+	return NewDocumentFromData(filename, declared, data)
+}
+
+// NewDocumentFromData creates a document from raw file data, declared is the content type
+// given by the client and may be empty
+func NewDocumentFromData(filename string, declared string, data []byte) (*Document, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty file")
+	}
+	declared = strings.ToLower(strings.TrimSpace(strings.SplitN(declared, ";", 2)[0]))
+	filename = sanitizeFilename(filename)
+	doc := &Document{Filename: filename, Data: data}
+	doc.ContentType = documentType(filename, declared, data)
+	if doc.Filename == "" {
+		doc.Filename = utils.RandomString(5) + documentExtension(doc.ContentType)
+	}
+	return doc, nil
+}
+
+// sanitizeFilename keeps the base name of a filename sent by the client without control
+// characters, shortened to maxFilenameBytes with its extension kept, "" when nothing is left
+func sanitizeFilename(filename string) string {
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, filename)
+	// 只保留文件名，去掉客户端可能带上的路径
+	filename = strings.TrimSpace(path.Base(strings.ReplaceAll(filename, "\\", "/")))
+	if filename == "." || filename == ".." || filename == "/" {
+		return ""
+	}
+	if len(filename) > maxFilenameBytes {
+		ext := path.Ext(filename)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		// 截断可能落在多字节字符中间，去掉不完整的字符
+		filename = strings.ToValidUTF8(filename[:maxFilenameBytes-len(ext)], "") + ext
+	}
+	return filename
+}
+
+// IsImage reports whether the document is an image, images are uploaded with UploadImage
+func (d *Document) IsImage() bool {
+	return strings.HasPrefix(d.ContentType, "image/")
+}
+
+// DataURL returns the document as a data URL
+func (d *Document) DataURL() string {
+	return "data:" + d.ContentType + ";base64," + base64.StdEncoding.EncodeToString(d.Data)
+}
+
+func documentType(filename string, declared string, data []byte) string {
+	if contentType := DetectImageType(data); contentType != "" {
+		return contentType
+	}
+	if declared != "" && declared != "application/octet-stream" {
+		return declared
+	}
+	if contentType, ok := documentTypes[strings.ToLower(path.Ext(filename))]; ok {
+		return contentType
+	}
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return contentType
+}
+
+func documentExtension(contentType string) string {
+	if ext := imageExtensions[contentType]; ext != "" {
+		return ext
+	}
+	found := ""
+	for ext, t := range documentTypes {
+		if t == contentType && t != "text/plain" && (found == "" || ext < found) {
+			found = ext
+		}
+	}
+	if found != "" {
+		return found
+	}
+	if strings.HasPrefix(contentType, "text/") {
+		return ".txt"
+	}
+	return ".bin"
+}
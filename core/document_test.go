@@ -0,0 +1,44 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNewDocumentFromDataFilename(t *testing.T) {
+	long := strings.Repeat("报", 100) + ".pdf"
+	tests := []struct {
+		name     string
+		filename string
+		// want is the expected filename, empty when a generated name is expected
+		want string
+	}{
+		{"plain", "report.pdf", "report.pdf"},
+		{"path", "../../etc/passwd.txt", "passwd.txt"},
+		{"windows path", `C:\Users\me\notes.md`, "notes.md"},
+		{"line breaks", "a\r\nContent-Type: text/html.txt", "html.txt"},
+		{"control characters", "re\x00po\x1brt\x7f.csv", "report.csv"},
+		{"only control characters", "\r\n\t", ""},
+		{"dot dot", "..", ""},
+		{"empty", "", ""},
+		{"long name keeps the extension", long, strings.Repeat("报", 65) + ".pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := NewDocumentFromData(tt.filename, "text/plain", []byte("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != "" && doc.Filename != tt.want {
+				t.Errorf("Filename = %q, want %q", doc.Filename, tt.want)
+			}
+			if tt.want == "" && !strings.HasSuffix(doc.Filename, ".txt") {
+				t.Errorf("Filename = %q, want a generated .txt name", doc.Filename)
+			}
+			if len(doc.Filename) > maxFilenameBytes || !utf8.ValidString(doc.Filename) || strings.ContainsAny(doc.Filename, "\r\n/\\") {
+				t.Errorf("Filename = %q is not a safe name", doc.Filename)
+			}
+		})
+	}
+}
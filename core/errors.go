@@ -0,0 +1,38 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// UpstreamError is a non-200 answer from Perplexity or its upload targets
+type UpstreamError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// InputError is a request the upload cannot be made for, e.g. undecodable image data,
+// retrying on another session would fail the same way
+type InputError struct {
+	Message string
+}
+
+func (e *InputError) Error() string {
+	return e.Message
+}
+
+// StatusCode returns the upstream status code carried by err, or 0 if there is none
+func StatusCode(err error) int {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.StatusCode
+	}
+	return 0
+}
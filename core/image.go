@@ -0,0 +1,206 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"pplx2api/config"
+	"pplx2api/utils"
+	"strings"
+
+	"github.com/gen2brain/heic"
+	"golang.org/x/image/bmp"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// imageFile is an image ready to be uploaded
+type imageFile struct {
+	Data        []byte
+	Filename    string
+	ContentType string
+}
+
+// imageExtensions maps the image types to the file extension of the upload
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+	"image/tiff": ".tiff",
+	"image/heic": ".heic",
+	"image/heif": ".heif",
+	"image/avif": ".avif",
+}
+
+// uploadableImageTypes are accepted by Perplexity as they are
+var uploadableImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// prepareImage decodes a data URL or plain base64 image, detects its real type and,
+// depending on the config, converts unsupported formats and downscales oversized images
+func prepareImage(cfg *config.Config, value string) (*imageFile, error) {
+	declared := ""
+	if strings.HasPrefix(value, "data:") {
+		header, data, ok := strings.Cut(value, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid data URL")
+		}
+		declared = strings.ToLower(strings.SplitN(strings.TrimPrefix(header, "data:"), ";", 2)[0])
+		value = data
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		if data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "=")); err != nil {
+			return nil, fmt.Errorf("invalid base64 image data: %w", err)
+		}
+	}
+	// 以文件内容为准，data URL 声明的类型可能与实际格式不符
+	contentType := DetectImageType(data)
+	if contentType == "" {
+		if declared != "" {
+			return nil, fmt.Errorf("image data does not match a supported image format (declared %s)", declared)
+		}
+		return nil, fmt.Errorf("image data does not match a supported image format")
+	}
+	file := &imageFile{Data: data, ContentType: contentType}
+
+	convert := cfg.ImageTranscode && !uploadableImageTypes[contentType]
+	if convert || cfg.ImageMaxDimension > 0 {
+		if err := file.process(convert, cfg.ImageMaxDimension, cfg.ImageMaxPixels); err != nil {
+			return nil, err
+		}
+	}
+	file.Filename = utils.RandomString(5) + imageExtensions[file.ContentType]
+	return file, nil
+}
+
+// process re-encodes the image when it has to be converted or is larger than maxDimension.
+// The dimensions are read from the header first, images with more than maxPixels pixels
+// are rejected before decoding allocates memory for them.
+func (f *imageFile) process(convert bool, maxDimension int, maxPixels int) error {
+	codec, ok := imageCodecs[f.ContentType]
+	if !ok || (f.ContentType == "image/gif" && !convert) {
+		// AVIF 无法解码，GIF 缩放会丢失动画，保持原样上传
+		return nil
+	}
+	header, err := codec.decodeConfig(bytes.NewReader(f.Data))
+	if err != nil {
+		if convert {
+			return fmt.Errorf("failed to decode %s image: %w", f.ContentType, err)
+		}
+		return nil
+	}
+	if header.Width <= 0 || header.Height <= 0 {
+		return fmt.Errorf("invalid %s image size %dx%d", f.ContentType, header.Width, header.Height)
+	}
+	if maxPixels > 0 && int64(header.Width)*int64(header.Height) > int64(maxPixels) {
+		return fmt.Errorf("image is %dx%d, more than the limit of %d pixels", header.Width, header.Height, maxPixels)
+	}
+	if !convert && header.Width <= maxDimension && header.Height <= maxDimension {
+		return nil
+	}
+	img, err := codec.decode(bytes.NewReader(f.Data))
+	if err != nil {
+		if convert {
+			return fmt.Errorf("failed to decode %s image: %w", f.ContentType, err)
+		}
+		return nil
+	}
+	scaled := downscale(img, maxDimension)
+	if scaled == img && !convert {
+		return nil
+	}
+	var buf bytes.Buffer
+	contentType := "image/png"
+	if f.ContentType == "image/jpeg" || (f.ContentType != "image/png" && opaque(scaled)) {
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, scaled)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode image: %w", err)
+	}
+	f.Data = buf.Bytes()
+	f.ContentType = contentType
+	return nil
+}
+
+// imageCodec reads the header and decodes the image of one format
+type imageCodec struct {
+	decode       func(r io.Reader) (image.Image, error)
+	decodeConfig func(r io.Reader) (image.Config, error)
+}
+
+var imageCodecs = map[string]imageCodec{
+	"image/jpeg": {jpeg.Decode, jpeg.DecodeConfig},
+	"image/png":  {png.Decode, png.DecodeConfig},
+	"image/gif":  {gif.Decode, gif.DecodeConfig},
+	"image/webp": {webp.Decode, webp.DecodeConfig},
+	"image/bmp":  {bmp.Decode, bmp.DecodeConfig},
+	"image/tiff": {tiff.Decode, tiff.DecodeConfig},
+	"image/heic": {heic.Decode, heic.DecodeConfig},
+	"image/heif": {heic.Decode, heic.DecodeConfig},
+}
+
+// downscale shrinks img so that neither side is longer than maxDimension
+func downscale(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxDimension <= 0 || (width <= maxDimension && height <= maxDimension) {
+		return img
+	}
+	if width >= height {
+		height = max(1, height*maxDimension/width)
+		width = maxDimension
+	} else {
+		width = max(1, width*maxDimension/height)
+		height = maxDimension
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// DetectImageType returns the image type from the magic bytes of data, or "" when it is unknown
+func DetectImageType(data []byte) string {
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		// HEIF 系列格式以 ftyp box 开头，按品牌区分
+		switch string(data[8:12]) {
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "heim", "heis", "hevc", "hevx", "hevm", "hevs":
+			return "image/heic"
+		case "mif1", "msf1":
+			return "image/heif"
+		}
+	}
+	if len(data) >= 4 && (string(data[:4]) == "II*\x00" || string(data[:4]) == "MM\x00*") {
+		return "image/tiff"
+	}
+	if contentType := http.DetectContentType(data); imageExtensions[contentType] != "" {
+		return contentType
+	}
+	return ""
+}
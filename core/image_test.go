@@ -0,0 +1,124 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"pplx2api/config"
+	"strings"
+	"testing"
+)
+
+// forgedBMP returns a bitmap header claiming the given size without pixel data
+func forgedBMP(width, height int32) []byte {
+	header := make([]byte, 54)
+	copy(header, "BM")
+	binary.LittleEndian.PutUint32(header[2:], 54)
+	binary.LittleEndian.PutUint32(header[10:], 54)
+	binary.LittleEndian.PutUint32(header[14:], 40)
+	binary.LittleEndian.PutUint32(header[18:], uint32(width))
+	binary.LittleEndian.PutUint32(header[22:], uint32(height))
+	binary.LittleEndian.PutUint16(header[26:], 1)
+	binary.LittleEndian.PutUint16(header[28:], 24)
+	return header
+}
+
+func pngImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.RGBA{A: 0x80})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPrepareImage(t *testing.T) {
+	heicData, err := os.ReadFile("testdata/sample.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		value     string
+		transcode bool
+		maxDim    int
+		wantType  string
+		wantSize  int
+		wantErr   string
+	}{
+		{
+			name:      "forged bmp size",
+			value:     base64.StdEncoding.EncodeToString(forgedBMP(20000, 20000)),
+			transcode: true,
+			wantErr:   "more than the limit",
+		},
+		{
+			name:    "forged bmp size when only downscaling",
+			value:   base64.StdEncoding.EncodeToString(forgedBMP(20000, 20000)),
+			maxDim:  1024,
+			wantErr: "more than the limit",
+		},
+		{
+			name:      "heic is transcoded",
+			value:     "data:image/heic;base64," + base64.StdEncoding.EncodeToString(heicData),
+			transcode: true,
+			wantType:  "image/jpeg",
+		},
+		{
+			name:     "heic is kept without transcoding",
+			value:    base64.StdEncoding.EncodeToString(heicData),
+			wantType: "image/heic",
+		},
+		{
+			name:     "png is downscaled",
+			value:    base64.StdEncoding.EncodeToString(pngImage(t, 300, 150)),
+			maxDim:   100,
+			wantType: "image/png",
+			wantSize: 100,
+		},
+		{
+			name:    "unknown type",
+			value:   "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("not an image at all")),
+			wantErr: "supported image format",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Current().Clone()
+			cfg.ImageTranscode = tt.transcode
+			cfg.ImageMaxDimension = tt.maxDim
+			cfg.ImageMaxPixels = 40000000
+			file, err := prepareImage(cfg, tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("prepareImage() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("prepareImage() error = %v", err)
+			}
+			if file.ContentType != tt.wantType || DetectImageType(file.Data) != tt.wantType {
+				t.Fatalf("content type = %s (detected %s), want %s", file.ContentType, DetectImageType(file.Data), tt.wantType)
+			}
+			if tt.wantSize > 0 {
+				header, _, err := image.DecodeConfig(bytes.NewReader(file.Data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if max(header.Width, header.Height) != tt.wantSize {
+					t.Errorf("size = %dx%d, want longest side %d", header.Width, header.Height, tt.wantSize)
+				}
+			}
+		})
+	}
+}
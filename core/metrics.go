@@ -0,0 +1,33 @@
+package core
+
+import (
+	"pplx2api/metrics"
+	"pplx2api/model"
+	"time"
+)
+
+// firstTokenRenderer records the time to the first reasoning or answer text of an answer
+type firstTokenRenderer struct {
+	model.Renderer
+	start    time.Time
+	model    string
+	observed bool
+}
+
+func (r *firstTokenRenderer) observe(text string) {
+	if r.observed || text == "" {
+		return
+	}
+	r.observed = true
+	metrics.UpstreamTTFT.WithLabelValues(metrics.ModelLabel(r.model)).Observe(time.Since(r.start).Seconds())
+}
+
+func (r *firstTokenRenderer) Thinking(text string) error {
+	r.observe(text)
+	return r.Renderer.Thinking(text)
+}
+
+func (r *firstTokenRenderer) Text(text string) error {
+	r.observe(text)
+	return r.Renderer.Text(text)
+}
@@ -0,0 +1,36 @@
+package core
+
+import (
+	"pplx2api/config"
+	"pplx2api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Upstream is the Perplexity backend the service handlers talk to.
+// core.Client is the real implementation; mocks, recorded fixtures or
+// alternative backends can be plugged in through an UpstreamFactory.
+type Upstream interface {
+	// SendMessage asks the question and renders the answer through out
+	SendMessage(message string, isIncognito bool, out model.Renderer, gc *gin.Context) (int, error)
+	// UploadImage uploads images given as data URLs or base64 data as attachments
+	UploadImage(imgList []string) error
+	// UploadText uploads a long context as a text attachment
+	UploadText(context string) error
+	// UploadFiles uploads documents such as PDFs as attachments
+	UploadFiles(files []Document) error
+	// GetNewCookie refreshes the session and returns the new session token
+	GetNewCookie() (string, error)
+	// SetRequestID tags the logs of the upstream with the id of the request it serves
+	SetRequestID(requestID string)
+}
+
+// UpstreamFactory creates an Upstream bound to a session, configured by the config snapshot of the request
+type UpstreamFactory func(cfg *config.Config, sessionToken string, model string, openSearch bool) Upstream
+
+// NewUpstream is the default UpstreamFactory backed by core.Client
+func NewUpstream(cfg *config.Config, sessionToken string, model string, openSearch bool) Upstream {
+	return NewClient(cfg, sessionToken, model, openSearch)
+}
+
+var _ Upstream = (*Client)(nil)
@@ -0,0 +1,335 @@
+// Package fakepplx implements a local stand-in for the Perplexity endpoints
+// used by core.Client: the SSE ask endpoint, upload URL creation, the
+// Cloudinary and S3 upload targets and the session refresh endpoint.
+package fakepplx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"pplx2api/config"
+	"strings"
+	"sync"
+)
+
+// SessionCookieName is the cookie carrying the Perplexity session token
+const SessionCookieName = "__Secure-next-auth.session-token"
+
+// WebResult is a search result returned in the completed event
+type WebResult struct {
+	Name    string `json:"name"`
+	Snippet string `json:"snippet"`
+	URL     string `json:"url"`
+}
+
+// Reply describes what the ask endpoint streams back
+type Reply struct {
+	// Goals are sent as reasoning plan blocks before the answer
+	Goals []string
+	// Chunks are sent one markdown block per event
+	Chunks []string
+	// WebResults are attached to the completed event
+	WebResults []WebResult
+	// DisplayModel defaults to the requested model_preference
+	DisplayModel string
+}
+
+// AskRequest is the part of a perplexity_ask body the fake server records
+type AskRequest struct {
+	SessionToken string
+	QueryStr     string   `json:"query_str"`
+	Params       AskParam `json:"params"`
+}
+
+type AskParam struct {
+	Attachments     []string `json:"attachments"`
+	ModelPreference string   `json:"model_preference"`
+	SearchFocus     string   `json:"search_focus"`
+	Sources         []string `json:"sources"`
+	IsIncognito     bool     `json:"is_incognito"`
+}
+
+// Upload records a file received by the Cloudinary or S3 endpoint
+type Upload struct {
+	Target   string
+	Filename string
+	Size     int
+}
+
+// Server is a fake Perplexity backend
+type Server struct {
+	mu            sync.Mutex
+	reply         Reply
+	sessionStatus map[string]int
+	asks          []AskRequest
+	uploads       []Upload
+	refreshes     []string
+	mux           *http.ServeMux
+}
+
+// New creates a fake server answering with a short default reply
+func New() *Server {
+	s := &Server{
+		reply: Reply{
+			Chunks: []string{"Hello", " from", " fake", " Perplexity"},
+		},
+		sessionStatus: map[string]int{},
+		mux:           http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/rest/sse/perplexity_ask", s.handleAsk)
+	s.mux.HandleFunc("/rest/uploads/create_upload_url", s.handleCreateUploadURL)
+	s.mux.HandleFunc("/v1_1/", s.handleCloudinaryUpload)
+	s.mux.HandleFunc("/s3/", s.handleS3Upload)
+	s.mux.HandleFunc("/api/auth/session", s.handleSession)
+	return s
+}
+
+// Start runs the fake server on a random local port
+func Start() (*Server, *httptest.Server) {
+	s := New()
+	return s, httptest.NewServer(s)
+}
+
+// Apply publishes a copy of the current config whose upstream URLs point at a fake server listening on baseURL
+func Apply(baseURL string) {
+	baseURL = strings.TrimRight(baseURL, "/")
+	cfg := config.Current().Clone()
+	cfg.PplxBaseURL = baseURL
+	cfg.CloudinaryBaseURL = baseURL
+	cfg.CloudinaryAssetURL = baseURL + "/assets"
+	cfg.S3UploadURL = baseURL + "/s3/"
+	config.Store(cfg)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// SetReply changes the answer streamed by the ask endpoint
+func (s *Server) SetReply(reply Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reply = reply
+}
+
+// SetSessionStatus makes every request authenticated with token fail with status,
+// a status of 0 or 200 restores normal behaviour
+func (s *Server) SetSessionStatus(token string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status == 0 || status == http.StatusOK {
+		delete(s.sessionStatus, token)
+		return
+	}
+	s.sessionStatus[token] = status
+}
+
+// Asks returns the ask requests received so far
+func (s *Server) Asks() []AskRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AskRequest(nil), s.asks...)
+}
+
+// Uploads returns the files received so far
+func (s *Server) Uploads() []Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Upload(nil), s.uploads...)
+}
+
+// Refreshes returns the session tokens that requested a refresh
+func (s *Server) Refreshes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.refreshes...)
+}
+
+// RefreshedToken is the token handed out when token refreshes its session
+func RefreshedToken(token string) string {
+	return token + ".refreshed"
+}
+
+func sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// checkSession writes the configured failure for the session, if any
+func (s *Server) checkSession(w http.ResponseWriter, token string) bool {
+	s.mu.Lock()
+	status, ok := s.sessionStatus[token]
+	s.mu.Unlock()
+	if !ok {
+		return true
+	}
+	http.Error(w, http.StatusText(status), status)
+	return false
+}
+
+func (s *Server) handleAsk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var ask AskRequest
+	if err := json.NewDecoder(r.Body).Decode(&ask); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ask.SessionToken = sessionToken(r)
+	if !s.checkSession(w, ask.SessionToken) {
+		return
+	}
+	s.mu.Lock()
+	s.asks = append(s.asks, ask)
+	reply := s.reply
+	s.mu.Unlock()
+
+	displayModel := reply.DisplayModel
+	if displayModel == "" {
+		displayModel = ask.Params.ModelPreference
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	send := func(event map[string]interface{}) {
+		data, _ := json.Marshal(event)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	for _, goal := range reply.Goals {
+		send(map[string]interface{}{
+			"status": "PENDING",
+			"blocks": []interface{}{
+				map[string]interface{}{
+					"reasoning_plan_block": map[string]interface{}{
+						"goals": []interface{}{map[string]string{"description": goal}},
+					},
+				},
+			},
+		})
+	}
+	for _, chunk := range reply.Chunks {
+		send(map[string]interface{}{
+			"status": "PENDING",
+			"blocks": []interface{}{
+				map[string]interface{}{
+					"markdown_block": map[string]interface{}{"chunks": []string{chunk}},
+				},
+			},
+		})
+	}
+	completed := map[string]interface{}{
+		"status":        "COMPLETED",
+		"display_model": displayModel,
+		"blocks":        []interface{}{},
+	}
+	if len(reply.WebResults) > 0 {
+		completed["blocks"] = []interface{}{
+			map[string]interface{}{
+				"web_result_block": map[string]interface{}{"web_results": reply.WebResults},
+			},
+		}
+	}
+	send(completed)
+}
+
+func (s *Server) handleCreateUploadURL(w http.ResponseWriter, r *http.Request) {
+	if !s.checkSession(w, sessionToken(r)) {
+		return
+	}
+	var body struct {
+		Filename    string `json:"filename"`
+		ContentType string `json:"content_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"s3_bucket_url": "",
+		"s3_object_url": "",
+		"rate_limited":  false,
+		"fields": map[string]interface{}{
+			"timestamp":       1,
+			"unique_filename": "true",
+			"folder":          "user_uploads",
+			"use_filename":    "true",
+			"public_id":       "fake",
+			"resource_type":   "image",
+			"api_key":         "fake",
+			"cloud_name":      "fake",
+			"signature":       "fake",
+			"key":             "attachments/" + body.Filename,
+			"acl":             "private",
+			"policy":          "fake",
+		},
+	})
+}
+
+// recordUpload stores the file part of a multipart upload
+func (s *Server) recordUpload(r *http.Request, target string) error {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return err
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	s.mu.Lock()
+	s.uploads = append(s.uploads, Upload{Target: target, Filename: header.Filename, Size: int(header.Size)})
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Server) handleCloudinaryUpload(w http.ResponseWriter, r *http.Request) {
+	if err := s.recordUpload(r, "cloudinary"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, header, _ := r.FormFile("file")
+	writeJSON(w, map[string]interface{}{
+		"secure_url": "https://res.cloudinary.invalid/fake/image/private/user_uploads/fake/" + header.Filename,
+	})
+}
+
+func (s *Server) handleS3Upload(w http.ResponseWriter, r *http.Request) {
+	if err := s.recordUpload(r, "s3"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	token := sessionToken(r)
+	if !s.checkSession(w, token) {
+		return
+	}
+	s.mu.Lock()
+	s.refreshes = append(s.refreshes, token)
+	s.mu.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    RefreshedToken(token),
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+	})
+	writeJSON(w, map[string]interface{}{})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -3,13 +3,14 @@ package job
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
 	"os"
 	"sync"
 	"time"
 
 	"pplx2api/config"
 	"pplx2api/core"
+	"pplx2api/logger"
+	"pplx2api/metrics"
 )
 
 const (
@@ -31,9 +32,11 @@ type SessionConfig struct {
 type SessionUpdater struct {
 	interval    time.Duration
 	stopChan    chan struct{}
+	doneChan    chan struct{}
 	isRunning   bool
 	runningLock sync.Mutex
 	configPath  string
+	newUpstream core.UpstreamFactory
 }
 
 // NewSessionUpdater 创建一个新的会话更新器
@@ -44,10 +47,11 @@ func GetSessionUpdater(interval time.Duration) *SessionUpdater {
 		configPath := ConfigFileName
 
 		sessionUpdaterInstance = &SessionUpdater{
-			interval:   interval,
-			stopChan:   make(chan struct{}),
-			isRunning:  false,
-			configPath: configPath,
+			interval:    interval,
+			stopChan:    make(chan struct{}),
+			isRunning:   false,
+			configPath:  configPath,
+			newUpstream: core.NewUpstream,
 		}
 		// 初始化时从文件加载会话
 		sessionUpdaterInstance.loadSessionsFromFile()
@@ -55,43 +59,45 @@ func GetSessionUpdater(interval time.Duration) *SessionUpdater {
 	return sessionUpdaterInstance
 }
 
+// SetUpstreamFactory replaces the upstream used to refresh sessions
+func (su *SessionUpdater) SetUpstreamFactory(newUpstream core.UpstreamFactory) {
+	su.runningLock.Lock()
+	defer su.runningLock.Unlock()
+	su.newUpstream = newUpstream
+}
+
 // loadSessionsFromFile loads sessions from the config file if it exists
 func (su *SessionUpdater) loadSessionsFromFile() {
 	// Check if file exists
 	if _, err := os.Stat(su.configPath); os.IsNotExist(err) {
-		log.Println("No sessions config file found, will create on first update")
+		logger.Info("No sessions config file found, will create on first update")
 		return
 	}
 
 	// Read the file
 	data, err := ioutil.ReadFile(su.configPath)
 	if err != nil {
-		log.Printf("Failed to read sessions config file: %v", err)
+		logger.Error("Failed to read sessions config file: %v", err)
 		return
 	}
 
 	// Parse the JSON
 	var sessionConfig SessionConfig
 	if err := json.Unmarshal(data, &sessionConfig); err != nil {
-		log.Printf("Failed to parse sessions config file: %v", err)
+		logger.Error("Failed to parse sessions config file: %v", err)
 		return
 	}
 
 	// Update the config with loaded sessions
-	config.ConfigInstance.RwMutex.Lock()
-	config.ConfigInstance.Sessions = sessionConfig.Sessions
-	config.ConfigInstance.RwMutex.Unlock()
+	config.Current().SetSessions(sessionConfig.Sessions)
 
-	log.Printf("Loaded %d sessions from config file", len(sessionConfig.Sessions))
+	logger.Info("Loaded %d sessions from config file", len(sessionConfig.Sessions))
 }
 
 // saveSessionsToFile saves the current sessions to the config file
 func (su *SessionUpdater) saveSessionsToFile() error {
 	// Get current sessions
-	config.ConfigInstance.RwMutex.RLock()
-	sessionsCopy := make([]config.SessionInfo, len(config.ConfigInstance.Sessions))
-	copy(sessionsCopy, config.ConfigInstance.Sessions)
-	config.ConfigInstance.RwMutex.RUnlock()
+	sessionsCopy := config.Current().SessionsSnapshot()
 
 	// Create config structure
 	sessionConfig := SessionConfig{
@@ -110,7 +116,7 @@ func (su *SessionUpdater) saveSessionsToFile() error {
 		return err
 	}
 
-	log.Printf("Saved %d sessions to sessions.json file", len(sessionsCopy))
+	logger.Info("Saved %d sessions to sessions.json file", len(sessionsCopy))
 	return nil
 }
 
@@ -119,30 +125,36 @@ func (su *SessionUpdater) Start() {
 	su.runningLock.Lock()
 	defer su.runningLock.Unlock()
 	if su.isRunning {
-		log.Println("Session updater is already running")
+		logger.Warn("Session updater is already running")
 		return
 	}
 	su.isRunning = true
 	su.stopChan = make(chan struct{})
+	su.doneChan = make(chan struct{})
 	go su.runUpdateLoop()
-	log.Println("Session updater started with interval:", su.interval)
+	logger.Info("Session updater started with interval: %s", su.interval)
 }
 
-// Stop 停止定时更新任务
+// Stop 停止定时更新任务，并等待正在进行的更新完成
 func (su *SessionUpdater) Stop() {
 	su.runningLock.Lock()
-	defer su.runningLock.Unlock()
 	if !su.isRunning {
-		log.Println("Session updater is not running")
+		su.runningLock.Unlock()
+		logger.Warn("Session updater is not running")
 		return
 	}
 	close(su.stopChan)
 	su.isRunning = false
-	log.Println("Session updater stopped")
+	doneChan := su.doneChan
+	su.runningLock.Unlock()
+	// 更新过程中会获取 runningLock，需在释放锁后等待
+	<-doneChan
+	logger.Info("Session updater stopped")
 }
 
 // runUpdateLoop 运行更新循环
 func (su *SessionUpdater) runUpdateLoop() {
+	defer close(su.doneChan)
 	ticker := time.NewTicker(su.interval)
 	defer ticker.Stop()
 	// 立即执行一次更新
@@ -152,7 +164,7 @@ func (su *SessionUpdater) runUpdateLoop() {
 		case <-ticker.C:
 			su.updateAllSessions()
 		case <-su.stopChan:
-			log.Println("Update loop terminated")
+			logger.Info("Update loop terminated")
 			return
 		}
 	}
@@ -160,20 +172,20 @@ func (su *SessionUpdater) runUpdateLoop() {
 
 // updateAllSessions 更新所有会话
 func (su *SessionUpdater) updateAllSessions() {
-	log.Println("Starting session update for all sessions...")
+	logger.Info("Starting session update for all sessions...")
 	// 复制当前会话列表，避免长时间持有锁
-	config.ConfigInstance.RwMutex.RLock()
-	sessionsCopy := make([]config.SessionInfo, len(config.ConfigInstance.Sessions))
-	copy(sessionsCopy, config.ConfigInstance.Sessions)
-	proxy := config.ConfigInstance.Proxy
-	config.ConfigInstance.RwMutex.RUnlock()
+	cfg := config.Current()
+	sessionsCopy := cfg.SessionsSnapshot()
+	su.runningLock.Lock()
+	newUpstream := su.newUpstream
+	su.runningLock.Unlock()
 	// 如果没有会话需要更新，直接返回
 	if len(sessionsCopy) == 0 {
-		log.Println("No sessions to update")
+		logger.Info("No sessions to update")
 		return
 	}
-	// 创建更新后的会话切片
-	updatedSessions := make([]config.SessionInfo, len(sessionsCopy))
+	// 记录刷新前后的 key
+	replacements := make([]string, len(sessionsCopy))
 	var wg sync.WaitGroup
 	// 对每个会话执行更新
 	for i, session := range sessionsCopy {
@@ -182,30 +194,67 @@ func (su *SessionUpdater) updateAllSessions() {
 			defer wg.Done()
 			// 创建客户端并更新 cookie
 			// 写死 model 和 openSearch 参数
-			client := core.NewClient(origSession.SessionKey, proxy, "claude-3-opus-20240229", false)
+			client := newUpstream(cfg, origSession.SessionKey, "claude-3-opus-20240229", false)
 			newCookie, err := client.GetNewCookie()
 			if err != nil {
-				log.Printf("Failed to update session %d: %v", index, err)
+				metrics.SessionRefreshes.WithLabelValues("failure").Inc()
+				logger.With("session", config.SessionID(origSession.SessionKey)).Error("Failed to update session %d: %v", index, err)
 				// 如果更新失败，保留原始会话
-				updatedSessions[index] = origSession
 				return
 			}
-			// 创建更新后的会话对象
-			updatedSessions[index] = config.SessionInfo{
-				SessionKey: newCookie,
-			}
+			metrics.SessionRefreshes.WithLabelValues("success").Inc()
+			replacements[index] = newCookie
 		}(i, session)
 	}
 	// 等待所有更新完成
 	wg.Wait()
-	// 一次性替换所有会话
-	config.ConfigInstance.RwMutex.Lock()
-	config.ConfigInstance.Sessions = updatedSessions
-	config.ConfigInstance.RwMutex.Unlock()
-	log.Printf("All %d sessions have been updated", len(updatedSessions))
+	// 按 key 替换，保留更新期间通过管理接口增删的会话
+	updated := 0
+	for i, newCookie := range replacements {
+		if newCookie == "" {
+			continue
+		}
+		if err := cfg.ReplaceSessionKey(sessionsCopy[i].SessionKey, newCookie); err == nil {
+			updated++
+		}
+	}
+	logger.Info("%d of %d sessions have been updated", updated, len(sessionsCopy))
 
 	// 保存更新后的配置到文件
 	if err := su.saveSessionsToFile(); err != nil {
-		log.Printf("Failed to save updated config: %v", err)
+		logger.Error("Failed to save updated config: %v", err)
 	}
 }
+
+// RefreshAll 立即刷新所有会话
+func (su *SessionUpdater) RefreshAll() {
+	su.updateAllSessions()
+}
+
+// RefreshSession 立即刷新单个会话并保存，返回新的 session key
+func (su *SessionUpdater) RefreshSession(sessionKey string) (string, error) {
+	cfg := config.Current()
+	su.runningLock.Lock()
+	newUpstream := su.newUpstream
+	su.runningLock.Unlock()
+
+	client := newUpstream(cfg, sessionKey, "claude-3-opus-20240229", false)
+	newCookie, err := client.GetNewCookie()
+	if err != nil {
+		metrics.SessionRefreshes.WithLabelValues("failure").Inc()
+		return "", err
+	}
+	metrics.SessionRefreshes.WithLabelValues("success").Inc()
+	if err := cfg.ReplaceSessionKey(sessionKey, newCookie); err != nil {
+		return "", err
+	}
+	if err := su.saveSessionsToFile(); err != nil {
+		logger.Error("Failed to save updated config: %v", err)
+	}
+	return newCookie, nil
+}
+
+// SaveSessions 将当前会话保存到配置文件
+func (su *SessionUpdater) SaveSessions() error {
+	return su.saveSessionsToFile()
+}
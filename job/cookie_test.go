@@ -0,0 +1,154 @@
+package job
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"pplx2api/config"
+	"pplx2api/core"
+	"pplx2api/fakepplx"
+	"strings"
+	"testing"
+)
+
+// startFake points the config at a fake Perplexity with the given sessions and returns an updater saving to a temporary file
+func startFake(t *testing.T, sessions ...string) (*fakepplx.Server, *SessionUpdater) {
+	t.Helper()
+	previous := config.Current()
+	previousSessions := previous.SessionsSnapshot()
+	fake, srv := fakepplx.Start()
+	t.Cleanup(func() {
+		srv.Close()
+		config.Store(previous)
+		previous.SetSessions(previousSessions)
+		for _, key := range sessions {
+			config.Health.Remove(key)
+			config.Health.Remove(fakepplx.RefreshedToken(key))
+		}
+	})
+	fakepplx.Apply(srv.URL)
+	var infos []config.SessionInfo
+	for _, key := range sessions {
+		infos = append(infos, config.SessionInfo{SessionKey: key})
+	}
+	config.Current().SetSessions(infos)
+	su := &SessionUpdater{
+		configPath:  filepath.Join(t.TempDir(), ConfigFileName),
+		newUpstream: core.NewUpstream,
+	}
+	return fake, su
+}
+
+// savedSessions reads the session keys written by the updater
+func savedSessions(t *testing.T, su *SessionUpdater) []string {
+	t.Helper()
+	data, err := os.ReadFile(su.configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved SessionConfig
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, session := range saved.Sessions {
+		keys = append(keys, session.SessionKey)
+	}
+	return keys
+}
+
+func currentSessions() []string {
+	var keys []string
+	for _, session := range config.Current().SessionsSnapshot() {
+		keys = append(keys, session.SessionKey)
+	}
+	return keys
+}
+
+func TestRefreshAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions []string
+		failing  map[string]int
+		want     []string
+	}{
+		{
+			name:     "every session refreshed",
+			sessions: []string{"refresh-a", "refresh-b"},
+			want:     []string{fakepplx.RefreshedToken("refresh-a"), fakepplx.RefreshedToken("refresh-b")},
+		},
+		{
+			name:     "failed refresh keeps the session",
+			sessions: []string{"refresh-ok", "refresh-expired"},
+			failing:  map[string]int{"refresh-expired": http.StatusUnauthorized},
+			want:     []string{fakepplx.RefreshedToken("refresh-ok"), "refresh-expired"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, su := startFake(t, tt.sessions...)
+			for key, status := range tt.failing {
+				fake.SetSessionStatus(key, status)
+			}
+			su.RefreshAll()
+			if got := strings.Join(currentSessions(), ","); got != strings.Join(tt.want, ",") {
+				t.Errorf("sessions = %s, want %s", got, strings.Join(tt.want, ","))
+			}
+			if got := strings.Join(savedSessions(t, su), ","); got != strings.Join(tt.want, ",") {
+				t.Errorf("saved sessions = %s, want %s", got, strings.Join(tt.want, ","))
+			}
+			if got := len(fake.Refreshes()); got != len(tt.sessions)-len(tt.failing) {
+				t.Errorf("refreshes = %d, want %d", got, len(tt.sessions)-len(tt.failing))
+			}
+		})
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	fake, su := startFake(t, "single-a", "single-b")
+	newKey, err := su.RefreshSession("single-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newKey != fakepplx.RefreshedToken("single-b") {
+		t.Errorf("new key = %q, want %q", newKey, fakepplx.RefreshedToken("single-b"))
+	}
+	want := "single-a," + fakepplx.RefreshedToken("single-b")
+	if got := strings.Join(currentSessions(), ","); got != want {
+		t.Errorf("sessions = %s, want %s", got, want)
+	}
+
+	fake.SetSessionStatus("single-a", http.StatusUnauthorized)
+	if _, err := su.RefreshSession("single-a"); err == nil {
+		t.Error("refreshing a rejected session succeeded")
+	}
+	if got := strings.Join(savedSessions(t, su), ","); got != want {
+		t.Errorf("saved sessions = %s, want %s", got, want)
+	}
+}
+
+func TestProbeInvalidSessions(t *testing.T) {
+	fake, _ := startFake(t, "probe-recovered", "probe-expired")
+	for _, key := range []string{"probe-recovered", "probe-expired"} {
+		for i := 0; i < config.Current().SessionMaxFailures; i++ {
+			config.Health.ReportFailure(key, http.StatusUnauthorized, nil)
+		}
+	}
+	fake.SetSessionStatus("probe-expired", http.StatusUnauthorized)
+	sp := &SessionProber{newUpstream: core.NewUpstream}
+	sp.ProbeInvalidSessions()
+
+	tests := []struct {
+		key  string
+		want config.SessionState
+	}{
+		{"probe-recovered", config.SessionHealthy},
+		{"probe-expired", config.SessionInvalid},
+	}
+	for _, tt := range tests {
+		if got := config.Health.Get(tt.key).State; got != tt.want {
+			t.Errorf("state of %s = %s, want %s", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package job
+
+import (
+	"sync"
+	"time"
+
+	"pplx2api/config"
+	"pplx2api/core"
+	"pplx2api/logger"
+)
+
+var (
+	sessionProberInstance *SessionProber
+	sessionProberOnce     sync.Once
+)
+
+// SessionProber 定时探测已失效的会话，探测成功后重新加入轮询
+type SessionProber struct {
+	interval    time.Duration
+	stopChan    chan struct{}
+	doneChan    chan struct{}
+	isRunning   bool
+	runningLock sync.Mutex
+	newUpstream core.UpstreamFactory
+}
+
+// GetSessionProber 获取会话探测器
+// interval: 探测间隔时间
+func GetSessionProber(interval time.Duration) *SessionProber {
+	sessionProberOnce.Do(func() {
+		sessionProberInstance = &SessionProber{
+			interval:    interval,
+			stopChan:    make(chan struct{}),
+			isRunning:   false,
+			newUpstream: core.NewUpstream,
+		}
+	})
+	return sessionProberInstance
+}
+
+// SetUpstreamFactory replaces the upstream used to probe sessions
+func (sp *SessionProber) SetUpstreamFactory(newUpstream core.UpstreamFactory) {
+	sp.runningLock.Lock()
+	defer sp.runningLock.Unlock()
+	sp.newUpstream = newUpstream
+}
+
+// Start 启动定时探测任务
+func (sp *SessionProber) Start() {
+	sp.runningLock.Lock()
+	defer sp.runningLock.Unlock()
+	if sp.isRunning {
+		logger.Warn("Session prober is already running")
+		return
+	}
+	sp.isRunning = true
+	sp.stopChan = make(chan struct{})
+	sp.doneChan = make(chan struct{})
+	go sp.runProbeLoop()
+	logger.Info("Session prober started with interval: %s", sp.interval)
+}
+
+// Stop 停止定时探测任务，并等待正在进行的探测完成
+func (sp *SessionProber) Stop() {
+	sp.runningLock.Lock()
+	if !sp.isRunning {
+		sp.runningLock.Unlock()
+		logger.Warn("Session prober is not running")
+		return
+	}
+	close(sp.stopChan)
+	sp.isRunning = false
+	doneChan := sp.doneChan
+	sp.runningLock.Unlock()
+	// 探测过程中会获取 runningLock，需在释放锁后等待
+	<-doneChan
+	logger.Info("Session prober stopped")
+}
+
+// runProbeLoop 运行探测循环
+func (sp *SessionProber) runProbeLoop() {
+	defer close(sp.doneChan)
+	ticker := time.NewTicker(sp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sp.ProbeInvalidSessions()
+		case <-sp.stopChan:
+			logger.Info("Probe loop terminated")
+			return
+		}
+	}
+}
+
+// ProbeInvalidSessions 探测所有已失效的会话
+func (sp *SessionProber) ProbeInvalidSessions() {
+	invalid := config.Health.Invalid()
+	if len(invalid) == 0 {
+		return
+	}
+	cfg := config.Current()
+	sp.runningLock.Lock()
+	newUpstream := sp.newUpstream
+	sp.runningLock.Unlock()
+
+	logger.Info("Probing %d invalid sessions...", len(invalid))
+	var wg sync.WaitGroup
+	for _, key := range invalid {
+		wg.Add(1)
+		go func(sessionKey string) {
+			defer wg.Done()
+			client := newUpstream(cfg, sessionKey, "claude-3-opus-20240229", false)
+			if _, err := client.GetNewCookie(); err != nil {
+				logger.With("session", config.SessionID(sessionKey)).Warn("Session probe failed: %v", err)
+				return
+			}
+			config.Health.MarkHealthy(sessionKey)
+			logger.With("session", config.SessionID(sessionKey)).Info("Session probe succeeded, session is back in rotation")
+		}(key)
+	}
+	wg.Wait()
+}
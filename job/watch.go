@@ -0,0 +1,104 @@
+package job
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"pplx2api/config"
+	"pplx2api/logger"
+)
+
+// ConfigWatcher 定时检查配置文件和密钥文件，修改后重新加载配置
+type ConfigWatcher struct {
+	interval    time.Duration
+	stopChan    chan struct{}
+	doneChan    chan struct{}
+	isRunning   bool
+	runningLock sync.Mutex
+	modTimes    map[string]time.Time
+}
+
+// NewConfigWatcher 创建配置文件监视器
+// interval: 检查间隔时间
+func NewConfigWatcher(interval time.Duration) *ConfigWatcher {
+	return &ConfigWatcher{
+		interval: interval,
+		modTimes: make(map[string]time.Time),
+	}
+}
+
+// Start 启动监视任务
+func (cw *ConfigWatcher) Start() {
+	cw.runningLock.Lock()
+	defer cw.runningLock.Unlock()
+	if cw.isRunning {
+		logger.Warn("Config watcher is already running")
+		return
+	}
+	// 记录当前的修改时间，避免启动后立即重新加载
+	cw.changed()
+	cw.isRunning = true
+	cw.stopChan = make(chan struct{})
+	cw.doneChan = make(chan struct{})
+	go cw.runWatchLoop()
+	logger.Info("Config watcher started with interval: %s", cw.interval)
+}
+
+// Stop 停止监视任务
+func (cw *ConfigWatcher) Stop() {
+	cw.runningLock.Lock()
+	if !cw.isRunning {
+		cw.runningLock.Unlock()
+		logger.Warn("Config watcher is not running")
+		return
+	}
+	close(cw.stopChan)
+	cw.isRunning = false
+	doneChan := cw.doneChan
+	cw.runningLock.Unlock()
+	<-doneChan
+	logger.Info("Config watcher stopped")
+}
+
+// runWatchLoop 运行监视循环
+func (cw *ConfigWatcher) runWatchLoop() {
+	defer close(cw.doneChan)
+	ticker := time.NewTicker(cw.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if cw.changed() {
+				config.Reload("file change")
+			}
+		case <-cw.stopChan:
+			logger.Info("Watch loop terminated")
+			return
+		}
+	}
+}
+
+// changed 检查被监视的文件是否有修改、新建或删除
+func (cw *ConfigWatcher) changed() bool {
+	cfg := config.Current()
+	paths := []string{cfg.KeysFile}
+	if cfg.ConfigFile != "" {
+		paths = append(paths, cfg.ConfigFile)
+	} else {
+		// 未使用配置文件时监视默认位置，新建后即可生效
+		paths = append(paths, config.DefaultConfigFiles...)
+	}
+	changed := false
+	for _, path := range paths {
+		var modTime time.Time
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+		}
+		if previous, ok := cw.modTimes[path]; ok && !previous.Equal(modTime) {
+			changed = true
+		}
+		cw.modTimes[path] = modTime
+	}
+	return changed
+}
@@ -1,8 +1,16 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	stdlog "log"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatih/color"
@@ -33,19 +41,23 @@ var levelColors = map[int]func(format string, a ...interface{}) string{
 	FATAL: color.New(color.FgHiRed, color.Bold).SprintfFunc(),
 }
 
-// 全局日志级别，默认为INFO
-var logLevel = INFO
+// 全局日志级别，默认为INFO，配置重新加载时会被并发修改
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(INFO)
+}
 
 // SetLevel 设置日志级别
 func SetLevel(level int) {
 	if level >= DEBUG && level <= FATAL {
-		logLevel = level
+		logLevel.Store(int32(level))
 	}
 }
 
 // GetLevel 获取当前日志级别
 func GetLevel() int {
-	return logLevel
+	return int(logLevel.Load())
 }
 
 // GetLevelName 获取日志级别名称
@@ -56,21 +68,98 @@ func GetLevelName(level int) string {
 	return "UNKNOWN"
 }
 
+// 日志输出格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// 全局日志格式，默认为彩色文本
+var jsonFormat atomic.Bool
+
+// SetFormat 设置日志格式，json 格式下标准库 log 的输出也会转为 JSON
+func SetFormat(format string) {
+	switch format {
+	case FormatJSON:
+		stdlog.SetFlags(0)
+		stdlog.SetOutput(Writer(INFO))
+		jsonFormat.Store(true)
+	case FormatText:
+		jsonFormat.Store(false)
+	}
+}
+
+// IsJSON 判断是否以 JSON 格式输出日志
+func IsJSON() bool {
+	return jsonFormat.Load()
+}
+
+// ParseLevel 解析日志级别名称
+func ParseLevel(name string) (int, bool) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, true
+		}
+	}
+	return INFO, false
+}
+
+// field 是附加在日志中的键值对
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Entry 带有附加字段的日志记录器
+type Entry struct {
+	fields []field
+}
+
+// RequestIDKey 请求 ID 在 gin context 中的 key
+const RequestIDKey = "request_id"
+
+// WithRequestID 返回带有请求 ID 的日志记录器
+func WithRequestID(requestID string) *Entry {
+	entry := &Entry{}
+	if requestID == "" {
+		return entry
+	}
+	return entry.With(RequestIDKey, requestID)
+}
+
+// With 返回带有一个字段的日志记录器，用于不属于请求的日志
+func With(key string, value interface{}) *Entry {
+	return (&Entry{}).With(key, value)
+}
+
+// With 返回附加了字段的日志记录器
+func (e *Entry) With(key string, value interface{}) *Entry {
+	fields := make([]field, 0, len(e.fields)+1)
+	fields = append(fields, e.fields...)
+	return &Entry{fields: append(fields, field{key: key, value: value})}
+}
+
 // 基础日志打印函数
-func log(level int, format string, args ...interface{}) {
-	if level < logLevel {
+func log(level int, fields []field, format string, args ...interface{}) {
+	if level < GetLevel() {
 		return
 	}
 
-	now := time.Now().Format("2006-01-02 15:04:05.000")
-	levelName := levelNames[level]
-	colorFunc := levelColors[level]
-
 	logContent := fmt.Sprintf(format, args...)
-	logPrefix := fmt.Sprintf("[%s] [%s] ", now, levelName)
+	if IsJSON() {
+		writeJSON(level, fields, logContent)
+	} else {
+		now := time.Now().Format("2006-01-02 15:04:05.000")
+		levelName := levelNames[level]
+		colorFunc := levelColors[level]
+		logPrefix := fmt.Sprintf("[%s] [%s] ", now, levelName)
+		for _, f := range fields {
+			logPrefix += fmt.Sprintf("[%s=%v] ", f.key, f.value)
+		}
 
-	// 使用颜色输出日志级别
-	fmt.Fprintf(os.Stdout, "%s%s\n", logPrefix, colorFunc(logContent))
+		// 使用颜色输出日志级别
+		fmt.Fprintf(os.Stdout, "%s%s\n", logPrefix, colorFunc(logContent))
+	}
 
 	// 如果是致命错误，则退出程序
 	if level == FATAL {
@@ -78,27 +167,135 @@ func log(level int, format string, args ...interface{}) {
 	}
 }
 
+// writeJSON 以单行 JSON 输出日志
+func writeJSON(level int, fields []field, message string) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeJSONValue(&buf, time.Now().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(&buf, levelNames[level])
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(&buf, message)
+	for _, f := range fields {
+		buf.WriteByte(',')
+		writeJSONValue(&buf, f.key)
+		buf.WriteByte(':')
+		writeJSONValue(&buf, f.value)
+	}
+	buf.WriteString("}\n")
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	os.Stdout.Write(buf.Bytes())
+}
+
+func writeJSONValue(buf *bytes.Buffer, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(data)
+}
+
+// outputMu 保证多行日志不会交错
+var outputMu sync.Mutex
+
+// levelWriter 将标准库 log、gin 等写入的文本转为指定级别的日志
+type levelWriter struct {
+	level int
+}
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	log(w.level, nil, "%s", strings.Trim(string(p), "\n"))
+	return len(p), nil
+}
+
+// Writer 返回按指定级别输出日志的 io.Writer，每次写入记为一条日志
+func Writer(level int) io.Writer {
+	return levelWriter{level: level}
+}
+
+// MaskSecret 隐藏密钥中间部分，只保留首尾少量字符
+func MaskSecret(secret string) string {
+	if len(secret) <= 12 {
+		return "****"
+	}
+	return secret[:6] + "****" + secret[len(secret)-4:]
+}
+
+// Secret 在非 DEBUG 级别下隐藏会话 token 和 API 密钥
+func Secret(secret string) string {
+	if GetLevel() == DEBUG {
+		return secret
+	}
+	return MaskSecret(secret)
+}
+
+// RedactURL 隐藏 URL 中的用户名、密码和查询参数（预签名 URL 的凭证在查询参数中），无法解析时整体隐藏
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "****"
+	}
+	if u.User != nil {
+		u.User = url.User("xxxxx")
+	}
+	if u.RawQuery != "" || u.ForceQuery {
+		u.RawQuery = "xxxxx"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// Prompt 在非 DEBUG 级别下隐藏用户提示词等内容，只保留长度
+func Prompt(content string) string {
+	if GetLevel() == DEBUG {
+		return content
+	}
+	return fmt.Sprintf("[%d chars redacted]", len(content))
+}
+
 // Debug 打印调试日志
 func Debug(format string, args ...interface{}) {
-	log(DEBUG, format, args...)
+	log(DEBUG, nil, format, args...)
 }
 
 // Info 打印信息日志
 func Info(format string, args ...interface{}) {
-	log(INFO, format, args...)
+	log(INFO, nil, format, args...)
 }
 
 // Warn 打印警告日志
 func Warn(format string, args ...interface{}) {
-	log(WARN, format, args...)
+	log(WARN, nil, format, args...)
 }
 
 // Error 打印错误日志
 func Error(format string, args ...interface{}) {
-	log(ERROR, format, args...)
+	log(ERROR, nil, format, args...)
 }
 
 // Fatal 打印致命错误日志并退出程序
 func Fatal(format string, args ...interface{}) {
-	log(FATAL, format, args...)
+	log(FATAL, nil, format, args...)
+}
+
+// Debug 打印带字段的调试日志
+func (e *Entry) Debug(format string, args ...interface{}) {
+	log(DEBUG, e.fields, format, args...)
+}
+
+// Info 打印带字段的信息日志
+func (e *Entry) Info(format string, args ...interface{}) {
+	log(INFO, e.fields, format, args...)
+}
+
+// Warn 打印带字段的警告日志
+func (e *Entry) Warn(format string, args ...interface{}) {
+	log(WARN, e.fields, format, args...)
+}
+
+// Error 打印带字段的错误日志
+func (e *Entry) Error(format string, args ...interface{}) {
+	log(ERROR, e.fields, format, args...)
 }
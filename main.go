@@ -1,27 +1,99 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
 	"pplx2api/config"
 	"pplx2api/job"
+	"pplx2api/logger"
+	"pplx2api/middleware"
 	"pplx2api/router"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	r := gin.Default()
+	// 访问日志由 middleware.LoggerMiddleware 输出，gin 自身的输出和 panic 也经由 logger 输出
+	gin.DefaultWriter = logger.Writer(logger.INFO)
+	gin.DefaultErrorWriter = logger.Writer(logger.ERROR)
+	r := gin.New()
+	r.Use(middleware.RecoveryMiddleware())
 	// Load configuration
 
-	// Setup all routes
-	router.SetupRoutes(r)
 	// 创建会话更新器，设置更新间隔为24小时
 	sessionUpdater := job.GetSessionUpdater(24 * time.Hour)
 
+	// Setup all routes
+	router.SetupRoutes(r, sessionUpdater)
+	cfg := config.Current()
+
 	// 启动会话更新器
 	sessionUpdater.Start()
-	defer sessionUpdater.Stop()
+	// 创建会话探测器，定时探测失效的会话
+	sessionProber := job.GetSessionProber(cfg.SessionProbeInterval)
+	sessionProber.Start()
+	// 配置重新加载后保存会话的增删
+	config.OnReload(func(*config.Config) {
+		if err := sessionUpdater.SaveSessions(); err != nil {
+			logger.Error(fmt.Sprintf("Failed to save sessions: %v", err))
+		}
+	})
+	// 监视配置文件，修改后自动重新加载
+	var configWatcher *job.ConfigWatcher
+	if cfg.ConfigWatchInterval > 0 {
+		configWatcher = job.NewConfigWatcher(cfg.ConfigWatchInterval)
+		configWatcher.Start()
+	}
+	// 收到 SIGHUP 时重新加载配置
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			config.Reload("SIGHUP")
+		}
+	}()
 
 	// Run the server on 0.0.0.0:8080
-	r.Run(config.ConfigInstance.Address)
+	srv := &http.Server{
+		Addr:    cfg.Address,
+		Handler: r,
+	}
+	go func() {
+		logger.Info(fmt.Sprintf("Listening on %s", srv.Addr))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal(fmt.Sprintf("Failed to start server: %v", err))
+		}
+	}()
+
+	// 等待退出信号
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	shutdownTimeout := config.Current().ShutdownTimeout
+	logger.Info(fmt.Sprintf("Received %s, waiting up to %s for active requests", sig, shutdownTimeout))
+
+	// 停止接收新请求，等待进行中的流式响应结束
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Warn(fmt.Sprintf("Active requests did not finish in time, closing them: %v", err))
+		srv.Close()
+	}
+
+	// 停止后台任务并保存会话
+	if configWatcher != nil {
+		configWatcher.Stop()
+	}
+	sessionProber.Stop()
+	sessionUpdater.Stop()
+	if err := sessionUpdater.SaveSessions(); err != nil {
+		logger.Error(fmt.Sprintf("Failed to save sessions: %v", err))
+	}
+	logger.Info("Server exited")
 }
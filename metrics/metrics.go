@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"pplx2api/config"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Default 全局指标注册表
+var Default = prometheus.NewRegistry()
+
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120}
+
+var (
+	// Requests 按接口、模型和状态码统计的请求数
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pplx2api_requests_total",
+		Help: "Requests handled by endpoint, model and status code.",
+	}, []string{"endpoint", "model", "status"})
+	// RequestDuration 请求处理耗时
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pplx2api_request_duration_seconds",
+		Help:    "Time spent handling a request.",
+		Buckets: latencyBuckets,
+	}, []string{"endpoint", "model"})
+	// UpstreamLatency SendMessage 的总耗时
+	UpstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pplx2api_upstream_latency_seconds",
+		Help:    "Time from sending a message to Perplexity until the answer is complete.",
+		Buckets: latencyBuckets,
+	}, []string{"model"})
+	// UpstreamTTFT SendMessage 到第一个输出的耗时
+	UpstreamTTFT = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pplx2api_upstream_time_to_first_token_seconds",
+		Help:    "Time from sending a message to Perplexity until the first reasoning or answer text.",
+		Buckets: latencyBuckets,
+	}, []string{"model"})
+	// Retries 每个请求切换会话重试的次数
+	Retries = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pplx2api_request_retries",
+		Help:    "Session retries needed per request.",
+		Buckets: []float64{0, 1, 2, 3, 5, 10},
+	})
+	// UploadFailures 上传附件失败次数
+	UploadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pplx2api_upload_failures_total",
+		Help: "Failed attachment uploads by kind.",
+	}, []string{"kind"})
+	// AttachmentCacheLookups 附件缓存的命中情况
+	AttachmentCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pplx2api_attachment_cache_lookups_total",
+		Help: "Attachment cache lookups by kind and result.",
+	}, []string{"kind", "result"})
+	// SessionPicks 轮询选中会话的次数
+	SessionPicks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pplx2api_session_picks_total",
+		Help: "Times a session was picked by the rotation.",
+	}, []string{"index"})
+	// SessionRefreshes 会话刷新结果
+	SessionRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pplx2api_session_refresh_total",
+		Help: "Session cookie refreshes by result.",
+	}, []string{"result"})
+	// ActiveStreams 正在进行的流式响应数量
+	ActiveStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pplx2api_active_streams",
+		Help: "Streaming responses in progress.",
+	})
+)
+
+func init() {
+	Default.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Requests, RequestDuration, UpstreamLatency, UpstreamTTFT, Retries,
+		UploadFailures, AttachmentCacheLookups, SessionPicks, SessionRefreshes, ActiveStreams,
+	)
+}
+
+// OtherModel is the model label of every model missing from the model map
+const OtherModel = "other"
+
+// ModelLabel returns the model label of a requested or upstream model name. Names come from
+// request bodies, so only models of the model map are kept to bound the number of series.
+func ModelLabel(name string) string {
+	if name == "" {
+		return ""
+	}
+	requested := strings.TrimSuffix(name, "-search")
+	if config.ModelMapGet(requested, "") != "" || config.ModelReverseMapGet(name, "") != "" {
+		return name
+	}
+	return OtherModel
+}
+
+// Handler serves the metrics of the Default registry
+var Handler = gin.WrapH(promhttp.HandlerFor(Default, promhttp.HandlerOpts{}))
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"pplx2api/config"
+	"testing"
+)
+
+func TestModelLabel(t *testing.T) {
+	config.SetModelMap(map[string]string{"claude-4.0-sonnet": "claude2"})
+	t.Cleanup(func() { config.SetModelMap(config.Current().ModelMap) })
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"claude-4.0-sonnet", "claude-4.0-sonnet"},
+		{"claude-4.0-sonnet-search", "claude-4.0-sonnet-search"},
+		{"claude2", "claude2"},
+		{"", ""},
+		{"made-up-model", OtherModel},
+		{"made-up-model-search", OtherModel},
+		{"claude-4.0-sonnet\nx", OtherModel},
+	}
+	for _, tt := range tests {
+		if got := ModelLabel(tt.name); got != tt.want {
+			t.Errorf("ModelLabel(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"pplx2api/config"
+	"pplx2api/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuthMiddleware protects the admin endpoints with the ADMIN_KEY
+func AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminKey := config.Current().AdminKey
+		if adminKey == "" {
+			model.AbortWithOpenAIError(c, 403, "admin_disabled", "Admin API is disabled, set ADMIN_KEY to enable it")
+			return
+		}
+		Key := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if Key == "" || !keyMatches(Key, adminKey) {
+			model.AbortWithOpenAIError(c, 401, "invalid_admin_key", "Invalid admin key")
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,31 +1,125 @@
 package middleware
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"pplx2api/config"
+	"pplx2api/model"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// APIKeyContextKey is the gin context key holding the config.APIKeyInfo of a key from the keys file
+const APIKeyContextKey = "api_key"
+
+// keyMatches compares a client supplied key with a configured one in constant time
+func keyMatches(key, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(key), []byte(want)) == 1
+}
+
 // AuthMiddleware initializes the Claude client from the request header
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		Key := c.GetHeader("Authorization")
-		if Key != "" {
-			Key = strings.TrimPrefix(Key, "Bearer ")
-			if Key != config.ConfigInstance.APIKey {
-				c.JSON(401, gin.H{
-					"error": "Invalid API key",
-				})
-				c.Abort()
-				return
-			}
+		if Key == "" {
+			// Anthropic clients send the key in x-api-key
+			Key = c.GetHeader("x-api-key")
+		}
+		if Key == "" {
+			model.AbortWithOpenAIError(c, 401, "missing_api_key", "Missing or invalid Authorization header")
+			return
+		}
+		Key = strings.TrimPrefix(Key, "Bearer ")
+		// APIKEY 环境变量中的密钥不受模型和配额限制
+		cfg := config.Current()
+		if cfg.APIKey != "" && keyMatches(Key, cfg.APIKey) {
 			c.Next()
 			return
 		}
-		c.JSON(401, gin.H{
-			"error": "Missing or invalid Authorization header",
-		})
-		c.Abort()
+		info, ok := cfg.FindAPIKey(Key)
+		if !ok {
+			model.AbortWithOpenAIError(c, 401, "invalid_api_key", "Invalid API key")
+			return
+		}
+		if info.Expired(time.Now()) {
+			model.AbortWithOpenAIError(c, 403, "api_key_expired", "API key has expired")
+			return
+		}
+		// 只有调用模型的请求才检查白名单并计入配额
+		if requested, ok := requestModel(c); ok {
+			if !info.AllowsModel(requested) {
+				model.AbortWithOpenAIError(c, 403, "model_not_allowed", "API key is not allowed to use model "+requested)
+				return
+			}
+			if err := config.Quotas.Acquire(info); err != nil {
+				code := "request_quota_exceeded"
+				if errors.Is(err, config.ErrTokenQuotaExceeded) {
+					code = "token_quota_exceeded"
+				}
+				model.AbortWithOpenAIError(c, 429, code, "API key "+err.Error())
+				return
+			}
+		}
+		c.Set(APIKeyContextKey, info)
+		c.Next()
+		// 请求完成后记录 token 用量
+		if usage, ok := c.Get(model.UsageContextKey); ok {
+			config.Quotas.AddTokens(info.Key, usage.(model.Usage).TotalTokens)
+		}
+	}
+}
+
+// requestBodyKey caches the request body read by peekRequest
+const requestBodyKey = "request_body"
+
+// peekRequest decodes a JSON request body into v and restores the body for the handler.
+// The body is read at most once and up to REQUEST_MAX_MB, larger requests are aborted with 413
+// because this runs before authentication.
+func peekRequest(c *gin.Context, v interface{}) bool {
+	if c.Request.Method != http.MethodPost || c.Request.Body == nil {
+		return false
+	}
+	// 文件上传不是 JSON，也不应整体读入内存
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		return false
+	}
+	var body []byte
+	if cached, ok := c.Get(requestBodyKey); ok {
+		body = cached.([]byte)
+	} else {
+		maxMB := config.Current().RequestMaxMB
+		var err error
+		body, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxMB)<<20))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				model.AbortWithOpenAIError(c, http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("Request body exceeds %d MB", maxMB))
+			}
+			return false
+		}
+		c.Set(requestBodyKey, body)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return json.Unmarshal(body, v) == nil
+}
+
+// requestModel returns the model a JSON request body asks for
+func requestModel(c *gin.Context) (string, bool) {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if !peekRequest(c, &req) {
+		return "", false
+	}
+	if req.Model == "" {
+		return config.DefaultModel, true
 	}
+	return req.Model, true
 }
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"pplx2api/config"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPeekRequestBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := config.Current()
+	t.Cleanup(func() { config.Store(previous) })
+	cfg := previous.Clone()
+	cfg.RequestMaxMB = 1
+	config.Store(cfg)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"small body reaches the handler", `{"model":"m","stream":true}`, http.StatusOK},
+		{"body at the limit", `{"model":"` + strings.Repeat("a", 1<<20-12) + `"}`, http.StatusOK},
+		{"body over the limit", `{"model":"` + strings.Repeat("a", 1<<20) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := false
+			r := gin.New()
+			r.POST("/", MetricsMiddleware(), RateLimitMiddleware(NewRateLimiter(0, 0)), func(c *gin.Context) {
+				handled = true
+				body, _ := c.GetRawData()
+				c.String(http.StatusOK, "%s", body)
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if handled != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler ran = %t", handled)
+			}
+			if tt.wantStatus == http.StatusOK && w.Body.String() != tt.body {
+				t.Errorf("handler read %d bytes, want the full body of %d bytes", w.Body.Len(), len(tt.body))
+			}
+			if tt.wantStatus != http.StatusOK && !strings.Contains(w.Body.String(), "request_too_large") {
+				t.Errorf("body = %s, want request_too_large", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestKeyChecks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := config.Current()
+	t.Cleanup(func() { config.Store(previous) })
+	cfg := previous.Clone()
+	cfg.APIKey = "api-secret"
+	cfg.AdminKey = "admin-secret"
+	cfg.APIKeys = []config.APIKeyInfo{{Key: "file-secret"}}
+	config.Store(cfg)
+
+	r := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/api", AuthMiddleware(), ok)
+	r.GET("/admin", AdminAuthMiddleware(), ok)
+	tests := []struct {
+		path       string
+		key        string
+		wantStatus int
+	}{
+		{"/api", "api-secret", http.StatusOK},
+		{"/api", "file-secret", http.StatusOK},
+		{"/api", "api-secre", http.StatusUnauthorized},
+		{"/api", "api-secret2", http.StatusUnauthorized},
+		{"/api", "file-secre", http.StatusUnauthorized},
+		{"/api", "admin-secret", http.StatusUnauthorized},
+		{"/admin", "admin-secret", http.StatusOK},
+		{"/admin", "admin-secre", http.StatusUnauthorized},
+		{"/admin", "api-secret", http.StatusUnauthorized},
+		{"/admin", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.key, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
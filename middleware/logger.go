@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"pplx2api/logger"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// validRequestID limits the request ids accepted from clients
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware assigns every request an id, reusing a valid X-Request-ID sent by the client
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if !validRequestID.MatchString(requestID) {
+			requestID = strings.ReplaceAll(uuid.New().String(), "-", "")
+		}
+		c.Set(logger.RequestIDKey, requestID)
+		c.Header("X-Request-ID", requestID)
+		c.Next()
+	}
+}
+
+// LoggerMiddleware writes an access log entry for every request
+func LoggerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		logger.WithRequestID(c.GetString(logger.RequestIDKey)).
+			With("method", c.Request.Method).
+			With("path", c.Request.URL.Path).
+			With("status", c.Writer.Status()).
+			With("latency_ms", time.Since(start).Milliseconds()).
+			With("client_ip", c.ClientIP()).
+			Info("%s %s %d", c.Request.Method, c.Request.URL.Path, c.Writer.Status())
+	}
+}
+
+// RecoveryMiddleware recovers from panics in handlers and logs them with the request id,
+// so that the stack trace follows the configured log format
+func RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err interface{}) {
+		logger.WithRequestID(c.GetString(logger.RequestIDKey)).
+			With("method", c.Request.Method).
+			With("path", c.Request.URL.Path).
+			Error("panic recovered: %v\n%s", err, debug.Stack())
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"pplx2api/config"
+	"pplx2api/metrics"
+	"pplx2api/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware records the requests, their duration and the active streams
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		var req struct {
+			Model  string `json:"model"`
+			Stream bool   `json:"stream"`
+		}
+		requestModel := ""
+		if peekRequest(c, &req) {
+			requestModel = req.Model
+			if requestModel == "" {
+				requestModel = config.DefaultModel
+			}
+			// 请求体未经认证，只记录模型映射中的模型
+			requestModel = metrics.ModelLabel(requestModel)
+		}
+		if req.Stream {
+			metrics.ActiveStreams.Inc()
+			defer metrics.ActiveStreams.Dec()
+		}
+		if !c.IsAborted() {
+			c.Next()
+		}
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+		metrics.Requests.WithLabelValues(endpoint, requestModel, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.RequestDuration.WithLabelValues(endpoint, requestModel).Observe(time.Since(start).Seconds())
+	}
+}
+
+// MetricsAuthMiddleware protects /metrics with METRICS_KEY, /metrics is public when it is not set
+func MetricsAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		metricsKey := config.Current().MetricsKey
+		if metricsKey == "" {
+			c.Next()
+			return
+		}
+		Key := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if Key == "" || !keyMatches(Key, metricsKey) {
+			model.AbortWithOpenAIError(c, 401, "invalid_metrics_key", "Invalid metrics key")
+			return
+		}
+		c.Next()
+	}
+}
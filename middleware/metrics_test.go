@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"pplx2api/config"
+	"pplx2api/metrics"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddlewareBoundsModelLabel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/chat/completions", MetricsMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	for _, requested := range []string{"made-up-1", "made-up-2", "made-up-3"} {
+		body := `{"model":"` + requested + `"}`
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+	}
+	if got := testutil.ToFloat64(metrics.Requests.WithLabelValues("/v1/chat/completions", metrics.OtherModel, "200")); got != 3 {
+		t.Errorf("requests of other models = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(metrics.Requests.WithLabelValues("/v1/chat/completions", "made-up-1", "200")); got != 0 {
+		t.Errorf("requests recorded under an unknown model = %v", got)
+	}
+}
+
+func TestMetricsAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := config.Current()
+	t.Cleanup(func() { config.Store(previous) })
+
+	tests := []struct {
+		name       string
+		metricsKey string
+		header     string
+		wantStatus int
+	}{
+		{"public without metrics key", "", "", http.StatusOK},
+		{"missing key", "secret", "", http.StatusUnauthorized},
+		{"wrong key", "secret", "Bearer other", http.StatusUnauthorized},
+		{"valid key", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := previous.Clone()
+			cfg.MetricsKey = tt.metricsKey
+			config.Store(cfg)
+			r := gin.New()
+			r.GET("/metrics", MetricsAuthMiddleware(), metrics.Handler)
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusOK && !strings.Contains(w.Body.String(), "pplx2api_active_streams") {
+				t.Errorf("metrics output misses pplx2api_active_streams")
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"pplx2api/config"
+	"pplx2api/model"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientBucket holds the request tokens and active streams of one client
+type clientBucket struct {
+	tokens  float64
+	last    time.Time
+	streams int
+}
+
+// RateLimiter is a token bucket per client refilled at rpm tokens per minute,
+// with an optional limit on the number of concurrent streams
+type RateLimiter struct {
+	mu         sync.Mutex
+	rpm        int
+	maxStreams int
+	buckets    map[string]*clientBucket
+	lastPrune  time.Time
+}
+
+// NewRateLimiter creates a RateLimiter, a limit of 0 disables it
+func NewRateLimiter(rpm int, maxStreams int) *RateLimiter {
+	return &RateLimiter{
+		rpm:        rpm,
+		maxStreams: maxStreams,
+		buckets:    make(map[string]*clientBucket),
+		lastPrune:  time.Now(),
+	}
+}
+
+// SetLimits changes the limits, e.g. after the config was reloaded
+func (l *RateLimiter) SetLimits(rpm int, maxStreams int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rpm = rpm
+	l.maxStreams = maxStreams
+}
+
+// bucket returns the refilled bucket of the client, the caller must hold the lock
+func (l *RateLimiter) bucket(client string, now time.Time) *clientBucket {
+	if now.Sub(l.lastPrune) > 10*time.Minute {
+		l.prune(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &clientBucket{tokens: float64(l.rpm), last: now}
+		l.buckets[client] = b
+		return b
+	}
+	b.tokens = math.Min(float64(l.rpm), b.tokens+now.Sub(b.last).Minutes()*float64(l.rpm))
+	b.last = now
+	return b
+}
+
+// prune drops idle clients whose bucket is full again
+func (l *RateLimiter) prune(now time.Time) {
+	for client, b := range l.buckets {
+		if b.streams == 0 && now.Sub(b.last) > time.Minute {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastPrune = now
+}
+
+// Allow takes a token from the client's bucket, it returns how long to wait when the bucket is empty
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rpm <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+	b := l.bucket(client, now)
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / float64(l.rpm) * float64(time.Minute))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// AcquireStream reserves a stream slot for the client
+func (l *RateLimiter) AcquireStream(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxStreams <= 0 {
+		return true
+	}
+	b := l.bucket(client, time.Now())
+	if b.streams >= l.maxStreams {
+		return false
+	}
+	b.streams++
+	return true
+}
+
+// ReleaseStream frees a stream slot reserved by AcquireStream
+func (l *RateLimiter) ReleaseStream(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[client]; ok && b.streams > 0 {
+		b.streams--
+	}
+}
+
+// clientKey identifies the caller by API key, or by IP when the key is missing or unknown
+func clientKey(c *gin.Context) string {
+	key := c.GetHeader("Authorization")
+	if key == "" {
+		key = c.GetHeader("x-api-key")
+	}
+	key = strings.TrimPrefix(key, "Bearer ")
+	cfg := config.Current()
+	if key != "" && key == cfg.APIKey {
+		return "key:" + key
+	}
+	if _, ok := cfg.FindAPIKey(key); ok && key != "" {
+		return "key:" + key
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// abortRateLimited returns an OpenAI style 429 with a Retry-After header
+func abortRateLimited(c *gin.Context, wait time.Duration, code string, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	model.AbortWithOpenAIError(c, http.StatusTooManyRequests, code, message)
+}
+
+// RateLimitMiddleware limits the requests per minute and concurrent streams of every client
+func RateLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := clientKey(c)
+		if ok, wait := limiter.Allow(client); !ok {
+			abortRateLimited(c, wait, "rate_limit_exceeded", "Rate limit reached for requests, please retry later")
+			return
+		}
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		if !peekRequest(c, &req) || !req.Stream {
+			if !c.IsAborted() {
+				c.Next()
+			}
+			return
+		}
+		if !limiter.AcquireStream(client) {
+			abortRateLimited(c, time.Second, "concurrent_streams_exceeded", "Too many concurrent streams, please retry later")
+			return
+		}
+		defer limiter.ReleaseStream(client)
+		c.Next()
+	}
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"pplx2api/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name string
+		rpm  int
+		// elapsed is the time passed since the bucket was emptied
+		elapsed   time.Duration
+		wantAfter bool
+		wantWait  time.Duration
+	}{
+		{"empty bucket", 3, 0, false, 20 * time.Second},
+		{"partly refilled", 3, 10 * time.Second, false, 10 * time.Second},
+		{"one token refilled", 3, 20 * time.Second, true, 0},
+		{"refill is capped at rpm", 60, time.Hour, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(tt.rpm, 0)
+			for i := 0; i < tt.rpm; i++ {
+				if ok, _ := l.Allow("client"); !ok {
+					t.Fatalf("request %d of a full bucket was denied", i+1)
+				}
+			}
+			l.mu.Lock()
+			l.buckets["client"].last = time.Now().Add(-tt.elapsed)
+			l.mu.Unlock()
+			ok, wait := l.Allow("client")
+			if ok != tt.wantAfter {
+				t.Fatalf("Allow() = %t, want %t", ok, tt.wantAfter)
+			}
+			if diff := wait - tt.wantWait; diff < -time.Second || diff > time.Second {
+				t.Errorf("wait = %v, want about %v", wait, tt.wantWait)
+			}
+			l.mu.Lock()
+			tokens := l.buckets["client"].tokens
+			l.mu.Unlock()
+			if tokens > float64(tt.rpm) {
+				t.Errorf("bucket holds %v tokens, more than %d", tokens, tt.rpm)
+			}
+		})
+	}
+}
+
+func TestRateLimiterClientsAreSeparate(t *testing.T) {
+	l := NewRateLimiter(1, 0)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("first request of a was denied")
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("b was limited by the requests of a")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("second request of a was allowed")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	l := NewRateLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("client"); !ok {
+			t.Fatal("disabled limiter denied a request")
+		}
+		if !l.AcquireStream("client") {
+			t.Fatal("disabled limiter denied a stream")
+		}
+	}
+}
+
+func TestRateLimiterStreams(t *testing.T) {
+	l := NewRateLimiter(0, 2)
+	steps := []struct {
+		acquire bool
+		want    bool
+	}{
+		{true, true},
+		{true, true},
+		{true, false},
+		{false, true},
+		{true, true},
+		{true, false},
+	}
+	for i, step := range steps {
+		if !step.acquire {
+			l.ReleaseStream("client")
+			continue
+		}
+		if got := l.AcquireStream("client"); got != step.want {
+			t.Fatalf("step %d: AcquireStream() = %t, want %t", i, got, step.want)
+		}
+	}
+	if !l.AcquireStream("other") {
+		t.Error("streams of another client were counted")
+	}
+}
+
+func TestRateLimiterSetLimits(t *testing.T) {
+	l := NewRateLimiter(1, 0)
+	l.Allow("client")
+	if ok, _ := l.Allow("client"); ok {
+		t.Fatal("second request was allowed")
+	}
+	l.SetLimits(0, 0)
+	if ok, _ := l.Allow("client"); !ok {
+		t.Error("request was denied after the limit was removed")
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	previous := config.Current()
+	t.Cleanup(func() { config.Store(previous) })
+	cfg := previous.Clone()
+	cfg.APIKey = "master"
+	cfg.APIKeys = []config.APIKeyInfo{{Key: "listed"}}
+	config.Store(cfg)
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"master key", "Bearer master", "key:master"},
+		{"listed key", "Bearer listed", "key:listed"},
+		{"unknown key falls back to ip", "Bearer made-up", "ip:192.0.2.1"},
+		{"no key", "", "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			c.Request.RemoteAddr = "192.0.2.1:1234"
+			if tt.header != "" {
+				c.Request.Header.Set("Authorization", tt.header)
+			}
+			if got := clientKey(c); got != tt.want {
+				t.Errorf("clientKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	limiter := NewRateLimiter(1, 0)
+	r.POST("/", RateLimitMiddleware(limiter), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	codes := []int{http.StatusOK, http.StatusTooManyRequests}
+	for i, want := range codes {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+		r.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("request %d status = %d, want %d", i+1, w.Code, want)
+		}
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "60" {
+			t.Errorf("Retry-After = %q, want 60", w.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pplx2api/logger"
+	"pplx2api/tokenizer"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AnthropicResponse 定义 Anthropic Messages API 的非流式响应结构
+type AnthropicResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+	// DisplayModel is the model that actually answered when it differs from Model
+	DisplayModel string `json:"display_model,omitempty"`
+}
+
+// AnthropicContentBlock 表示 thinking 或 text 内容块
+type AnthropicContentBlock struct {
+	Type      string  `json:"type"`
+	Text      *string `json:"text,omitempty"`
+	Thinking  *string `json:"thinking,omitempty"`
+	Signature *string `json:"signature,omitempty"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicDelta 表示 content_block_delta 中的增量内容
+type AnthropicDelta struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Thinking string `json:"thinking,omitempty"`
+}
+
+func newAnthropicBlock(blockType string, text string) AnthropicContentBlock {
+	if blockType == "thinking" {
+		signature := ""
+		return AnthropicContentBlock{Type: blockType, Thinking: &text, Signature: &signature}
+	}
+	return AnthropicContentBlock{Type: blockType, Text: &text}
+}
+
+// AnthropicRenderer renders the answer in the Anthropic Messages API format,
+// the reasoning process is rendered as thinking blocks
+type AnthropicRenderer struct {
+	gc           *gin.Context
+	stream       bool
+	id           string
+	model        string
+	displayModel string
+	promptTokens int
+	started      bool
+	blockType    string
+	index        int
+	blocks       []AnthropicContentBlock
+	current      strings.Builder
+	completion   strings.Builder
+	stopReason   string
+	stopSequence *string
+}
+
+func NewAnthropicRenderer(gc *gin.Context, stream bool, model string, promptTokens int) *AnthropicRenderer {
+	return &AnthropicRenderer{
+		gc:           gc,
+		stream:       stream,
+		id:           "msg_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		model:        model,
+		promptTokens: promptTokens,
+		index:        -1,
+		stopReason:   "end_turn",
+	}
+}
+
+func (r *AnthropicRenderer) Start() error {
+	if !r.stream {
+		return nil
+	}
+	r.gc.Writer.Header().Set("Content-Type", "text/event-stream")
+	r.gc.Writer.Header().Set("Cache-Control", "no-cache")
+	r.gc.Writer.Header().Set("Connection", "keep-alive")
+	r.gc.Writer.WriteHeader(http.StatusOK)
+	r.started = true
+	return r.event("message_start", gin.H{
+		"type": "message_start",
+		"message": AnthropicResponse{
+			ID:      r.id,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   r.model,
+			Content: []AnthropicContentBlock{},
+			Usage:   AnthropicUsage{InputTokens: r.promptTokens},
+		},
+	})
+}
+
+func (r *AnthropicRenderer) Thinking(text string) error {
+	return r.write("thinking", text)
+}
+
+func (r *AnthropicRenderer) Text(text string) error {
+	return r.write("text", text)
+}
+
+// SearchResults is a no-op, the Messages API has no field for them
+func (r *AnthropicRenderer) SearchResults(results []SearchResult) {}
+
+// DisplayModel records the model that actually answered, model stays the requested one
+// and the display model is sent with the final message
+func (r *AnthropicRenderer) DisplayModel(model string) {
+	if model != r.model {
+		r.displayModel = model
+	}
+}
+
+// Stopped reports a stop_sequence or max_tokens stop reason
+func (r *AnthropicRenderer) Stopped(reason string, sequence string) {
+	switch reason {
+	case FinishStop:
+		r.stopReason = "stop_sequence"
+		r.stopSequence = &sequence
+	case FinishLength:
+		r.stopReason = "max_tokens"
+	}
+}
+
+func (r *AnthropicRenderer) Finish() error {
+	if err := r.closeBlock(); err != nil {
+		return err
+	}
+	usage := r.usage()
+	r.gc.Set(UsageContextKey, Usage{
+		PromptTokens:     usage.InputTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      usage.InputTokens + usage.OutputTokens,
+	})
+	stopReason := r.stopReason
+	if !r.stream {
+		content := r.blocks
+		if content == nil {
+			content = []AnthropicContentBlock{}
+		}
+		r.gc.JSON(http.StatusOK, AnthropicResponse{
+			ID:           r.id,
+			Type:         "message",
+			Role:         "assistant",
+			Model:        r.model,
+			Content:      content,
+			StopReason:   &stopReason,
+			StopSequence: r.stopSequence,
+			Usage:        usage,
+			DisplayModel: r.displayModel,
+		})
+		return nil
+	}
+	delta := gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": stopReason, "stop_sequence": r.stopSequence},
+		"usage": gin.H{"output_tokens": usage.OutputTokens},
+	}
+	if r.displayModel != "" {
+		delta["display_model"] = r.displayModel
+	}
+	if err := r.event("message_delta", delta); err != nil {
+		return err
+	}
+	return r.event("message_stop", gin.H{"type": "message_stop"})
+}
+
+func (r *AnthropicRenderer) Started() bool {
+	return r.started
+}
+
+func (r *AnthropicRenderer) Error(status int, code string, message string) {
+	errorType := "api_error"
+	switch status {
+	case http.StatusBadRequest:
+		errorType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errorType = "authentication_error"
+	case http.StatusForbidden:
+		errorType = "permission_error"
+	case http.StatusTooManyRequests:
+		errorType = "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		errorType = "overloaded_error"
+	}
+	body := gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    errorType,
+			"message": message,
+		},
+	}
+	if r.started {
+		// 流式响应已经开始，只能在流中返回错误事件
+		r.event("error", body)
+		return
+	}
+	r.gc.JSON(status, body)
+}
+
+// write appends text to the current block, opening a new block when the type changes
+func (r *AnthropicRenderer) write(blockType string, text string) error {
+	if text == "" {
+		return nil
+	}
+	if r.blockType != blockType {
+		if err := r.closeBlock(); err != nil {
+			return err
+		}
+		r.blockType = blockType
+		r.index++
+		if r.stream {
+			if err := r.event("content_block_start", gin.H{
+				"type":          "content_block_start",
+				"index":         r.index,
+				"content_block": newAnthropicBlock(blockType, ""),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	r.current.WriteString(text)
+	r.completion.WriteString(text)
+	if !r.stream {
+		return nil
+	}
+	delta := AnthropicDelta{Type: "text_delta", Text: text}
+	if blockType == "thinking" {
+		delta = AnthropicDelta{Type: "thinking_delta", Thinking: text}
+	}
+	return r.event("content_block_delta", gin.H{
+		"type":  "content_block_delta",
+		"index": r.index,
+		"delta": delta,
+	})
+}
+
+// usage estimates the token usage of the prompt and everything written so far
+func (r *AnthropicRenderer) usage() AnthropicUsage {
+	return AnthropicUsage{
+		InputTokens:  r.promptTokens,
+		OutputTokens: tokenizer.Count(r.completion.String()),
+	}
+}
+
+// closeBlock finishes the current content block
+func (r *AnthropicRenderer) closeBlock() error {
+	if r.blockType == "" {
+		return nil
+	}
+	r.blocks = append(r.blocks, newAnthropicBlock(r.blockType, r.current.String()))
+	r.blockType = ""
+	r.current.Reset()
+	if !r.stream {
+		return nil
+	}
+	return r.event("content_block_stop", gin.H{
+		"type":  "content_block_stop",
+		"index": r.index,
+	})
+}
+
+func (r *AnthropicRenderer) event(name string, data interface{}) error {
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error marshalling JSON: %v", err))
+		return err
+	}
+	fmt.Fprintf(r.gc.Writer, "event: %s\ndata: %s\n\n", name, jsonBytes)
+	r.gc.Writer.Flush()
+	return nil
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAIError 定义 OpenAI 的错误对象
+type OpenAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// OpenAIErrorResponse 定义 OpenAI 的错误响应结构
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
+}
+
+// ErrorType 根据状态码返回 OpenAI 的错误类型
+func ErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusRequestEntityTooLarge:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	default:
+		return "api_error"
+	}
+}
+
+// NewOpenAIError 创建错误响应，code 为空时返回 null
+func NewOpenAIError(status int, code string, message string) OpenAIErrorResponse {
+	resp := OpenAIErrorResponse{
+		Error: OpenAIError{
+			Message: message,
+			Type:    ErrorType(status),
+		},
+	}
+	if code != "" {
+		resp.Error.Code = &code
+	}
+	return resp
+}
+
+// AbortWithOpenAIError 返回错误响应并中止请求
+func AbortWithOpenAIError(gc *gin.Context, status int, code string, message string) {
+	gc.AbortWithStatusJSON(status, NewOpenAIError(status, code, message))
+}
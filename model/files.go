@@ -0,0 +1,28 @@
+package model
+
+// FilePurposes 是 OpenAI Files API 接受的 purpose
+var FilePurposes = []string{"assistants", "batch", "fine-tune", "vision", "user_data", "evals"}
+
+// FileObject 定义 OpenAI 的文件对象
+type FileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int    `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+// FileList 定义文件列表响应结构
+type FileList struct {
+	Object  string       `json:"object"`
+	Data    []FileObject `json:"data"`
+	HasMore bool         `json:"has_more"`
+}
+
+// FileDeleted 定义删除文件的响应结构
+type FileDeleted struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ResponseFormat is the response_format of an OpenAI chat completion request
+type ResponseFormat struct {
+	// Type is text, json_object or json_schema
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat describes the structured output a json_schema response format asks for
+type JSONSchemaFormat struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Strict      bool                   `json:"strict,omitempty"`
+}
+
+// OutputFormatError reports an answer that does not match the requested response format
+type OutputFormatError struct {
+	Reason string
+}
+
+func (e *OutputFormatError) Error() string {
+	return "answer is not valid JSON: " + e.Reason
+}
+
+// Check validates the response format of the request
+func (f *ResponseFormat) Check() error {
+	switch f.Type {
+	case "text", "json_object":
+		return nil
+	case "json_schema":
+		if f.JSONSchema == nil {
+			return fmt.Errorf("response_format.json_schema is required for type json_schema")
+		}
+		if err := CheckJSONSchema(f.JSONSchema.Schema); err != nil {
+			return fmt.Errorf("invalid response_format.json_schema.schema: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid response_format type %q, expected text, json_object or json_schema", f.Type)
+}
+
+// JSON reports whether the answer has to be JSON
+func (f *ResponseFormat) JSON() bool {
+	return f != nil && f.Type != "" && f.Type != "text"
+}
+
+// sectionSeparator starts the search results and model monitoring sections appended to the answer
+const sectionSeparator = "\n\n---\n"
+
+// Extract returns the JSON document of the answer, without markdown fences and the
+// appended search results and model monitoring sections, validated against the schema
+func (f *ResponseFormat) Extract(answer string) (string, error) {
+	candidates := []string{answer}
+	if i := strings.Index(answer, sectionSeparator); i >= 0 {
+		candidates = append(candidates, answer[:i])
+	}
+	var lastErr error
+	for _, candidate := range candidates {
+		document := unfence(candidate)
+		var value interface{}
+		if err := json.Unmarshal([]byte(document), &value); err != nil {
+			lastErr = &OutputFormatError{Reason: err.Error()}
+			continue
+		}
+		if err := f.validate(value); err != nil {
+			return "", err
+		}
+		return document, nil
+	}
+	return "", lastErr
+}
+
+func (f *ResponseFormat) validate(value interface{}) error {
+	if f.Type == "json_object" {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return &OutputFormatError{Reason: "expected a JSON object, got " + typeName(value)}
+		}
+	}
+	if f.Type == "json_schema" && f.JSONSchema.Schema != nil {
+		if err := ValidateJSONSchema(f.JSONSchema.Schema, value); err != nil {
+			return &OutputFormatError{Reason: "does not match the schema: " + err.Error()}
+		}
+	}
+	return nil
+}
+
+// unfence returns the content of the first fenced code block, or the text between
+// the outermost braces or brackets when the answer is not fenced
+func unfence(text string) string {
+	text = strings.TrimSpace(text)
+	if match := fencedBlock.FindStringSubmatch(text); match != nil {
+		return strings.TrimSpace(match[2])
+	}
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return text
+	}
+	closing := "}"
+	if text[start] == '[' {
+		closing = "]"
+	}
+	end := strings.LastIndex(text, closing)
+	if end < start {
+		return text
+	}
+	return text[start : end+1]
+}
@@ -0,0 +1,361 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// ValidateJSONSchema checks value, decoded with encoding/json, against schema.
+// It supports the subset of JSON Schema used by OpenAI structured outputs: type,
+// properties, required, additionalProperties, items, enum, const, anyOf, oneOf,
+// allOf, the length, size and range keywords, pattern and local $ref.
+func ValidateJSONSchema(schema map[string]interface{}, value interface{}) error {
+	v := &schemaValidator{root: schema}
+	return v.validate(schema, value, "$", nil)
+}
+
+// CheckJSONSchema reports the $refs of a schema that cannot be resolved and the $ref
+// cycles that would be followed without ever descending into the value
+func CheckJSONSchema(schema map[string]interface{}) error {
+	v := &schemaValidator{root: schema}
+	return v.check(schema, map[string]bool{})
+}
+
+const (
+	// maxSchemaRefs limits the $refs followed for one value
+	maxSchemaRefs = 64
+	// maxSchemaSteps limits the subschemas applied while validating one value, so that
+	// a schema from the client cannot make validation run for an unbounded time
+	maxSchemaSteps = 100000
+)
+
+type schemaValidator struct {
+	root  map[string]interface{}
+	steps int
+}
+
+// validate checks value against schema, refs are the $refs already followed for this value
+func (v *schemaValidator) validate(schema map[string]interface{}, value interface{}, path string, refs []string) error {
+	v.steps++
+	if v.steps > maxSchemaSteps {
+		return fmt.Errorf("%s: schema is too complex", path)
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		if err := checkRef(ref, refs); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		resolved, err := v.resolve(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return v.validate(resolved, value, path, append(refs, ref))
+	}
+	if types, ok := schemaTypes(schema["type"]); ok {
+		matched := false
+		for _, t := range types {
+			if hasType(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), typeName(value))
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if reflect.DeepEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the enum values", path)
+		}
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		return fmt.Errorf("%s: value does not equal const", path)
+	}
+	if err := v.validateCombinators(schema, value, path, refs); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return v.validateObject(schema, value, path)
+	case []interface{}:
+		return v.validateArray(schema, value, path)
+	case string:
+		return validateString(schema, value, path)
+	case float64:
+		return validateNumber(schema, value, path)
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateCombinators(schema map[string]interface{}, value interface{}, path string, refs []string) error {
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				if err := v.validate(subSchema, value, path, refs); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, keyword := range []string{"anyOf", "oneOf"} {
+		options, ok := schema[keyword].([]interface{})
+		if !ok {
+			continue
+		}
+		matches := 0
+		for _, sub := range options {
+			subSchema, ok := sub.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			err := v.validate(subSchema, value, path, refs)
+			if err == nil {
+				matches++
+			} else if v.steps > maxSchemaSteps {
+				return err
+			}
+		}
+		if matches == 0 || (keyword == "oneOf" && matches > 1) {
+			return fmt.Errorf("%s: value does not match %s", path, keyword)
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateObject(schema map[string]interface{}, value map[string]interface{}, path string) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, exists := value[key]; !exists {
+					return fmt.Errorf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	for key, item := range value {
+		itemPath := path + "." + key
+		if propertySchema, ok := properties[key].(map[string]interface{}); ok {
+			if err := v.validate(propertySchema, item, itemPath, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: additional property is not allowed", itemPath)
+			}
+		case map[string]interface{}:
+			if err := v.validate(additional, item, itemPath, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateArray(schema map[string]interface{}, value []interface{}, path string) error {
+	if min, ok := schemaNumber(schema["minItems"]); ok && float64(len(value)) < min {
+		return fmt.Errorf("%s: expected at least %v items", path, min)
+	}
+	if max, ok := schemaNumber(schema["maxItems"]); ok && float64(len(value)) > max {
+		return fmt.Errorf("%s: expected at most %v items", path, max)
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range value {
+			if err := v.validate(items, item, fmt.Sprintf("%s[%d]", path, i), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateString(schema map[string]interface{}, value string, path string) error {
+	length := float64(len([]rune(value)))
+	if min, ok := schemaNumber(schema["minLength"]); ok && length < min {
+		return fmt.Errorf("%s: expected at least %v characters", path, min)
+	}
+	if max, ok := schemaNumber(schema["maxLength"]); ok && length > max {
+		return fmt.Errorf("%s: expected at most %v characters", path, max)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern %q: %w", path, pattern, err)
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("%s: value does not match pattern %q", path, pattern)
+		}
+	}
+	return nil
+}
+
+func validateNumber(schema map[string]interface{}, value float64, path string) error {
+	if min, ok := schemaNumber(schema["minimum"]); ok && value < min {
+		return fmt.Errorf("%s: expected a value of at least %v", path, min)
+	}
+	if max, ok := schemaNumber(schema["maximum"]); ok && value > max {
+		return fmt.Errorf("%s: expected a value of at most %v", path, max)
+	}
+	if min, ok := schemaNumber(schema["exclusiveMinimum"]); ok && value <= min {
+		return fmt.Errorf("%s: expected a value greater than %v", path, min)
+	}
+	if max, ok := schemaNumber(schema["exclusiveMaximum"]); ok && value >= max {
+		return fmt.Errorf("%s: expected a value less than %v", path, max)
+	}
+	return nil
+}
+
+// checkRef rejects a $ref that was already followed for the same value, which would never end
+func checkRef(ref string, refs []string) error {
+	for _, followed := range refs {
+		if followed == ref {
+			return fmt.Errorf("cyclic $ref %q", ref)
+		}
+	}
+	if len(refs) >= maxSchemaRefs {
+		return fmt.Errorf("more than %d nested $refs", maxSchemaRefs)
+	}
+	return nil
+}
+
+// check walks every subschema and follows its $refs, done holds the $refs already checked
+func (v *schemaValidator) check(node interface{}, done map[string]bool) error {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		if err := v.followRefs(node, nil, done); err != nil {
+			return err
+		}
+		for _, child := range node {
+			if err := v.check(child, done); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range node {
+			if err := v.check(child, done); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// followRefs follows the $refs and combinators that apply to the same value as schema
+func (v *schemaValidator) followRefs(schema map[string]interface{}, refs []string, done map[string]bool) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		if done[ref] {
+			return nil
+		}
+		if err := checkRef(ref, refs); err != nil {
+			return err
+		}
+		resolved, err := v.resolve(ref)
+		if err != nil {
+			return err
+		}
+		if err := v.followRefs(resolved, append(refs, ref), done); err != nil {
+			return err
+		}
+		done[ref] = true
+		return nil
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		options, _ := schema[keyword].([]interface{})
+		for _, sub := range options {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				if err := v.followRefs(subSchema, refs, done); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resolve looks up a local reference such as #/$defs/item
+func (v *schemaValidator) resolve(ref string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	var current interface{} = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		current = object[part]
+	}
+	schema, ok := current.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unresolvable $ref %q", ref)
+	}
+	return schema, nil
+}
+
+func schemaTypes(value interface{}) ([]string, bool) {
+	switch t := value.(type) {
+	case string:
+		return []string{t}, true
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types, len(types) > 0
+	}
+	return nil, false
+}
+
+func schemaNumber(value interface{}) (float64, bool) {
+	number, ok := value.(float64)
+	return number, ok
+}
+
+func hasType(value interface{}, t string) bool {
+	switch t {
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return typeName(value) == t
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64, json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,166 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	person := `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "maxLength": 5},
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+			"email": {"type": ["string", "null"], "pattern": "^[^@]+@[^@]+$"},
+			"role": {"enum": ["admin", "user"]},
+			"tags": {"type": "array", "items": {"type": "string"}, "minItems": 1, "maxItems": 2},
+			"address": {"$ref": "#/$defs/address"}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"$defs": {
+			"address": {"type": "object", "properties": {"city": {"const": "Paris"}}, "required": ["city"]}
+		}
+	}`
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		// wantErr is a fragment of the error, empty when the value is valid
+		wantErr string
+	}{
+		{"valid", person, `{"name": "Ann", "age": 30, "email": null, "role": "admin", "tags": ["a"], "address": {"city": "Paris"}}`, ""},
+		{"wrong root type", person, `[]`, "$: expected object, got array"},
+		{"missing required", person, `{"name": "Ann"}`, `missing required property "age"`},
+		{"additional property", person, `{"name": "Ann", "age": 1, "nick": "a"}`, "$.nick: additional property is not allowed"},
+		{"not an integer", person, `{"name": "Ann", "age": 1.5}`, "$.age: expected integer, got number"},
+		{"below minimum", person, `{"name": "Ann", "age": -1}`, "at least 0"},
+		{"exclusive maximum", person, `{"name": "Ann", "age": 150}`, "less than 150"},
+		{"too short", person, `{"name": "", "age": 1}`, "at least 1 characters"},
+		{"too long counts runes", person, `{"name": "Zoë Ann", "age": 1}`, "at most 5 characters"},
+		{"runes within length", person, `{"name": "Zoë", "age": 1}`, ""},
+		{"pattern", person, `{"name": "Ann", "age": 1, "email": "nope"}`, "does not match pattern"},
+		{"enum", person, `{"name": "Ann", "age": 1, "role": "root"}`, "$.role: value is not one of the enum values"},
+		{"item type", person, `{"name": "Ann", "age": 1, "tags": [1]}`, "$.tags[0]: expected string"},
+		{"too many items", person, `{"name": "Ann", "age": 1, "tags": ["a", "b", "c"]}`, "at most 2 items"},
+		{"ref", person, `{"name": "Ann", "age": 1, "address": {"city": "Rome"}}`, "$.address.city: value does not equal const"},
+		{"anyOf", `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `true`, "does not match anyOf"},
+		{"anyOf match", `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `1`, ""},
+		{"oneOf matches twice", `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1`, "does not match oneOf"},
+		{"oneOf matches once", `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1.5`, ""},
+		{"allOf", `{"allOf": [{"type": "number"}, {"minimum": 2}]}`, `1`, "at least 2"},
+		{"additionalProperties schema", `{"type": "object", "additionalProperties": {"type": "number"}}`, `{"a": 1, "b": "x"}`, "$.b: expected number"},
+		{"unresolvable ref", `{"$ref": "#/$defs/missing"}`, `1`, "unresolvable $ref"},
+		{"remote ref", `{"$ref": "https://example.com/schema.json"}`, `1`, "unsupported $ref"},
+		{"empty schema", `{}`, `{"anything": [1, "a", null]}`, ""},
+		{"self reference", `{"$ref": "#"}`, `{}`, `cyclic $ref "#"`},
+		{"defs cycle", `{"$ref": "#/$defs/a", "$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"allOf": [{"$ref": "#/$defs/a"}]}}}`, `1`, `cyclic $ref "#/$defs/a"`},
+		{"recursive schema", `{"$ref": "#/$defs/node", "$defs": {"node": {"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}}}}}`, `{"children": [{"children": []}, {"children": [{}]}]}`, ""},
+		{"recursive schema mismatch", `{"$ref": "#/$defs/node", "$defs": {"node": {"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}}}}}`, `{"children": [{"children": [1]}]}`, "$.children[0].children[0]: expected object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+				t.Fatal(err)
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			err := ValidateJSONSchema(schema, value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateJSONSchema() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateJSONSchema() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateJSONSchemaComplexity(t *testing.T) {
+	// 每一层通过 allOf 引用下一层两次，逐个展开需要 2^40 步
+	defs := map[string]interface{}{"d40": map[string]interface{}{"type": "number"}}
+	for i := 0; i < 40; i++ {
+		next := map[string]interface{}{"$ref": fmt.Sprintf("#/$defs/d%d", i+1)}
+		defs[fmt.Sprintf("d%d", i)] = map[string]interface{}{"allOf": []interface{}{next, next}}
+	}
+	schema := map[string]interface{}{"$ref": "#/$defs/d0", "$defs": defs}
+	if err := CheckJSONSchema(schema); err != nil {
+		t.Fatalf("CheckJSONSchema() error = %v", err)
+	}
+	if err := ValidateJSONSchema(schema, 1.0); err == nil || !strings.Contains(err.Error(), "schema is too complex") {
+		t.Errorf("ValidateJSONSchema() error = %v, want schema is too complex", err)
+	}
+}
+
+func TestResponseFormatExtract(t *testing.T) {
+	schema := &ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaFormat{
+		Name:   "answer",
+		Schema: map[string]interface{}{"type": "object", "required": []interface{}{"ok"}},
+	}}
+	tests := []struct {
+		name    string
+		format  *ResponseFormat
+		answer  string
+		want    string
+		wantErr bool
+	}{
+		{"plain object", &ResponseFormat{Type: "json_object"}, `{"ok": true}`, `{"ok": true}`, false},
+		{"fenced", &ResponseFormat{Type: "json_object"}, "Here you go:\n```json\n{\"ok\": true}\n```", `{"ok": true}`, false},
+		{"surrounding text", &ResponseFormat{Type: "json_object"}, `Sure! {"ok": true} Hope this helps.`, `{"ok": true}`, false},
+		{"search results appended", &ResponseFormat{Type: "json_object"}, "{\"ok\": true}\n\n---\n[1] https://example.com {x}", `{"ok": true}`, false},
+		{"array is not an object", &ResponseFormat{Type: "json_object"}, `[1, 2]`, "", true},
+		{"not json", &ResponseFormat{Type: "json_object"}, `I cannot answer that.`, "", true},
+		{"schema match", schema, `{"ok": false}`, `{"ok": false}`, false},
+		{"schema mismatch", schema, `{"fine": true}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.format.Extract(tt.answer)
+			if tt.wantErr {
+				var formatErr *OutputFormatError
+				if !errors.As(err, &formatErr) {
+					t.Fatalf("Extract() error = %v, want an OutputFormatError", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Extract() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseFormatCheck(t *testing.T) {
+	tests := []struct {
+		format  ResponseFormat
+		wantErr bool
+	}{
+		{ResponseFormat{Type: "text"}, false},
+		{ResponseFormat{Type: "json_object"}, false},
+		{ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaFormat{Name: "x"}}, false},
+		{ResponseFormat{Type: "json_schema"}, true},
+		{ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaFormat{Name: "x", Schema: map[string]interface{}{"$ref": "#"}}}, true},
+		{ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaFormat{Name: "x", Schema: map[string]interface{}{
+			"properties": map[string]interface{}{"a": map[string]interface{}{"$ref": "#/$defs/missing"}},
+		}}}, true},
+		{ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchemaFormat{Name: "x", Schema: map[string]interface{}{
+			"$defs":      map[string]interface{}{"node": map[string]interface{}{"properties": map[string]interface{}{"next": map[string]interface{}{"$ref": "#/$defs/node"}}}},
+			"properties": map[string]interface{}{"head": map[string]interface{}{"$ref": "#/$defs/node"}},
+		}}}, false},
+		{ResponseFormat{Type: "yaml"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.format.Check(); (err != nil) != tt.wantErr {
+			t.Errorf("Check(%+v) error = %v, want error %t", tt.format, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package model
+
+import (
+	"errors"
+	"pplx2api/tokenizer"
+	"strings"
+)
+
+// ErrAnswerComplete is returned by LimitRenderer once the answer reached a stop sequence
+// or the token limit, the caller should stop reading the upstream answer and Finish
+var ErrAnswerComplete = errors.New("answer complete")
+
+// Limits are the stop sequences and the token limit applied to the answer
+type Limits struct {
+	Stop []string
+	// MaxTokens is the estimated token limit of the answer, 0 means unlimited
+	MaxTokens int
+}
+
+// LimitRenderer cuts the answer of the wrapped Renderer at the first stop sequence
+// or at the token limit. The reasoning process is not limited.
+type LimitRenderer struct {
+	Renderer
+	limits Limits
+	// pending is the end of the answer that may be the beginning of a stop sequence
+	pending string
+	tokens  int
+	done    bool
+}
+
+// NewLimitRenderer wraps r, r is returned unchanged when there are no limits
+func NewLimitRenderer(r Renderer, limits Limits) Renderer {
+	stop := limits.Stop[:0:0]
+	for _, sequence := range limits.Stop {
+		if sequence != "" {
+			stop = append(stop, sequence)
+		}
+	}
+	limits.Stop = stop
+	if len(limits.Stop) == 0 && limits.MaxTokens <= 0 {
+		return r
+	}
+	return &LimitRenderer{Renderer: r, limits: limits}
+}
+
+func (r *LimitRenderer) Thinking(text string) error {
+	if r.done {
+		return ErrAnswerComplete
+	}
+	return r.Renderer.Thinking(text)
+}
+
+func (r *LimitRenderer) Text(text string) error {
+	if r.done {
+		return ErrAnswerComplete
+	}
+	text = r.pending + text
+	r.pending = ""
+	if index, sequence := firstStop(text, r.limits.Stop); index >= 0 {
+		if err := r.emit(text[:index]); err != nil {
+			return err
+		}
+		r.stop(FinishStop, sequence)
+		return ErrAnswerComplete
+	}
+	// 末尾可能是跨分块的停止序列的开头，等待下一个分块再输出
+	keep := len(text) - partialStop(text, r.limits.Stop)
+	r.pending = text[keep:]
+	if err := r.emit(text[:keep]); err != nil {
+		return err
+	}
+	if r.done {
+		return ErrAnswerComplete
+	}
+	return nil
+}
+
+func (r *LimitRenderer) Finish() error {
+	if !r.done && r.pending != "" {
+		if err := r.emit(r.pending); err != nil {
+			return err
+		}
+		r.pending = ""
+	}
+	return r.Renderer.Finish()
+}
+
+// emit writes text to the wrapped Renderer, truncated at the token limit
+func (r *LimitRenderer) emit(text string) error {
+	if text == "" || r.limits.MaxTokens <= 0 {
+		return r.Renderer.Text(text)
+	}
+	remaining := r.limits.MaxTokens - r.tokens
+	count := tokenizer.Count(text)
+	if count > remaining {
+		text = tokenizer.Truncate(text, remaining)
+		count = remaining
+		r.stop(FinishLength, "")
+	}
+	r.tokens += count
+	return r.Renderer.Text(text)
+}
+
+func (r *LimitRenderer) stop(reason string, sequence string) {
+	if r.done {
+		return
+	}
+	r.done = true
+	r.pending = ""
+	r.Renderer.Stopped(reason, sequence)
+}
+
+// firstStop returns the index and the stop sequence that occurs first in text, or -1
+func firstStop(text string, stop []string) (int, string) {
+	first, match := -1, ""
+	for _, sequence := range stop {
+		if i := strings.Index(text, sequence); i >= 0 && (first < 0 || i < first) {
+			first, match = i, sequence
+		}
+	}
+	return first, match
+}
+
+// partialStop returns the length of the longest end of text that is the beginning of a stop sequence
+func partialStop(text string, stop []string) int {
+	longest := 0
+	for _, sequence := range stop {
+		for n := min(len(sequence)-1, len(text)); n > longest; n-- {
+			if strings.HasSuffix(text, sequence[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
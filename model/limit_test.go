@@ -0,0 +1,180 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recordingRenderer records what reaches the client
+type recordingRenderer struct {
+	text     strings.Builder
+	thinking strings.Builder
+	reason   string
+	sequence string
+	finished bool
+}
+
+func (r *recordingRenderer) Start() error                         { return nil }
+func (r *recordingRenderer) Thinking(text string) error           { r.thinking.WriteString(text); return nil }
+func (r *recordingRenderer) Text(text string) error               { r.text.WriteString(text); return nil }
+func (r *recordingRenderer) SearchResults(results []SearchResult) {}
+func (r *recordingRenderer) DisplayModel(model string)            {}
+func (r *recordingRenderer) Stopped(reason string, sequence string) {
+	r.reason, r.sequence = reason, sequence
+}
+func (r *recordingRenderer) Finish() error                                 { r.finished = true; return nil }
+func (r *recordingRenderer) Started() bool                                 { return r.text.Len() > 0 }
+func (r *recordingRenderer) Error(status int, code string, message string) {}
+
+func TestLimitRenderer(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		chunks []string
+		want   string
+		// wantReason is the reported finish reason, empty when the answer was not cut
+		wantReason   string
+		wantSequence string
+		// wantStoppedAt is the chunk after which Text returns ErrAnswerComplete, -1 for none
+		wantStoppedAt int
+	}{
+		{
+			name:          "no stop sequence in the answer",
+			limits:        Limits{Stop: []string{"END"}},
+			chunks:        []string{"Hello", " world"},
+			want:          "Hello world",
+			wantStoppedAt: -1,
+		},
+		{
+			name:          "stop sequence inside a chunk",
+			limits:        Limits{Stop: []string{"END"}},
+			chunks:        []string{"Hello END world", " more"},
+			want:          "Hello ",
+			wantReason:    FinishStop,
+			wantSequence:  "END",
+			wantStoppedAt: 0,
+		},
+		{
+			name:          "stop sequence split across chunks",
+			limits:        Limits{Stop: []string{"STOP"}},
+			chunks:        []string{"Hello S", "T", "OP world"},
+			want:          "Hello ",
+			wantReason:    FinishStop,
+			wantSequence:  "STOP",
+			wantStoppedAt: 2,
+		},
+		{
+			name:          "partial stop sequence that does not complete",
+			limits:        Limits{Stop: []string{"STOP"}},
+			chunks:        []string{"Hello ST", "AR", " is born"},
+			want:          "Hello STAR is born",
+			wantStoppedAt: -1,
+		},
+		{
+			name:          "partial stop sequence at the end of the answer",
+			limits:        Limits{Stop: []string{"STOP"}},
+			chunks:        []string{"Hello ST"},
+			want:          "Hello ST",
+			wantStoppedAt: -1,
+		},
+		{
+			name:          "first of several stop sequences",
+			limits:        Limits{Stop: []string{"\n\n", "User:"}},
+			chunks:        []string{"Answer.", " User:", " hi\n\nmore"},
+			want:          "Answer. ",
+			wantReason:    FinishStop,
+			wantSequence:  "User:",
+			wantStoppedAt: 1,
+		},
+		{
+			name:          "empty stop sequences are ignored",
+			limits:        Limits{Stop: []string{"", "X"}},
+			chunks:        []string{"abc"},
+			want:          "abc",
+			wantStoppedAt: -1,
+		},
+		{
+			name:          "token limit",
+			limits:        Limits{MaxTokens: 2},
+			chunks:        []string{"hello", " world", " again"},
+			want:          "hello world",
+			wantReason:    FinishLength,
+			wantStoppedAt: 2,
+		},
+		{
+			name:          "token limit inside a chunk",
+			limits:        Limits{MaxTokens: 3},
+			chunks:        []string{"one two three four five"},
+			want:          "one two three",
+			wantReason:    FinishLength,
+			wantStoppedAt: 0,
+		},
+		{
+			name:          "token limit with a held back stop prefix",
+			limits:        Limits{Stop: []string{"STOP"}, MaxTokens: 10},
+			chunks:        []string{"short S"},
+			want:          "short S",
+			wantStoppedAt: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &recordingRenderer{}
+			r := NewLimitRenderer(recorder, tt.limits)
+			stoppedAt := -1
+			for i, chunk := range tt.chunks {
+				err := r.Text(chunk)
+				if errors.Is(err, ErrAnswerComplete) {
+					stoppedAt = i
+					break
+				}
+				if err != nil {
+					t.Fatalf("Text(%q) error = %v", chunk, err)
+				}
+			}
+			if err := r.Finish(); err != nil {
+				t.Fatalf("Finish() error = %v", err)
+			}
+			if stoppedAt != tt.wantStoppedAt {
+				t.Errorf("stopped after chunk %d, want %d", stoppedAt, tt.wantStoppedAt)
+			}
+			if got := recorder.text.String(); got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+			if recorder.reason != tt.wantReason || recorder.sequence != tt.wantSequence {
+				t.Errorf("stopped with %q %q, want %q %q", recorder.reason, recorder.sequence, tt.wantReason, tt.wantSequence)
+			}
+			if !recorder.finished {
+				t.Error("Finish was not passed on")
+			}
+		})
+	}
+}
+
+func TestLimitRendererDoesNotLimitThinking(t *testing.T) {
+	recorder := &recordingRenderer{}
+	r := NewLimitRenderer(recorder, Limits{Stop: []string{"STOP"}, MaxTokens: 1})
+	if err := r.Thinking("thinking STOP about many many words"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Text("answer STOP"); !errors.Is(err, ErrAnswerComplete) {
+		t.Fatalf("Text() error = %v, want ErrAnswerComplete", err)
+	}
+	if err := r.Thinking("late"); !errors.Is(err, ErrAnswerComplete) {
+		t.Errorf("Thinking() after the stop error = %v, want ErrAnswerComplete", err)
+	}
+	if got := recorder.thinking.String(); got != "thinking STOP about many many words" {
+		t.Errorf("thinking = %q", got)
+	}
+	if got := recorder.text.String(); got != "answer" {
+		t.Errorf("text = %q, want %q", got, "answer")
+	}
+}
+
+func TestNewLimitRendererWithoutLimits(t *testing.T) {
+	recorder := &recordingRenderer{}
+	if r := NewLimitRenderer(recorder, Limits{Stop: []string{""}}); r != Renderer(recorder) {
+		t.Errorf("NewLimitRenderer() without limits = %T, want the renderer unchanged", r)
+	}
+}
@@ -3,7 +3,10 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"pplx2api/logger"
+	"pplx2api/tokenizer"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -19,11 +22,16 @@ type ChatCompletionRequest struct {
 
 // OpenAISrteamResponse 定义 OpenAI 的流式响应结构
 type OpenAISrteamResponse struct {
-	ID      string         `json:"id"`
-	Object  string         `json:"object"`
-	Created int64          `json:"created"`
-	Model   string         `json:"model"`
-	Choices []StreamChoice `json:"choices"`
+	ID            string         `json:"id"`
+	Object        string         `json:"object"`
+	Created       int64          `json:"created"`
+	Model         string         `json:"model"`
+	Choices       []StreamChoice `json:"choices"`
+	Usage         *Usage         `json:"usage,omitempty"`
+	Citations     []string       `json:"citations,omitempty"`
+	SearchResults []SearchResult `json:"search_results,omitempty"`
+	// DisplayModel is the model that actually answered, sent in the final chunks when it differs from Model
+	DisplayModel string `json:"display_model,omitempty"`
 }
 
 // Choice 结构表示 OpenAI 返回的单个选项
@@ -43,22 +51,54 @@ type NoStreamChoice struct {
 
 // Delta 结构用于存储返回的文本内容
 type Delta struct {
-	Content string `json:"content"`
+	Content          string     `json:"content"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
 }
 type Message struct {
-	Role       string        `json:"role"`
-	Content    string        `json:"content"`
-	Refusal    interface{}   `json:"refusal"`
-	Annotation []interface{} `json:"annotation"`
+	Role             string        `json:"role"`
+	Content          string        `json:"content"`
+	ReasoningContent string        `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall    `json:"tool_calls,omitempty"`
+	Refusal          interface{}   `json:"refusal"`
+	Annotation       []interface{} `json:"annotation"`
+}
+
+// ReasoningMode 决定思考过程的输出方式
+type ReasoningMode string
+
+const (
+	// ReasoningThink 在 content 中用 <think> 标签包裹思考过程
+	ReasoningThink ReasoningMode = "think"
+	// ReasoningContent 通过独立的 reasoning_content 字段输出思考过程
+	ReasoningContent ReasoningMode = "reasoning_content"
+	// ReasoningHidden 不输出思考过程
+	ReasoningHidden ReasoningMode = "hidden"
+)
+
+// ParseReasoningMode 解析思考过程输出方式，空字符串返回 defaultMode
+func ParseReasoningMode(value string, defaultMode ReasoningMode) (ReasoningMode, error) {
+	switch mode := ReasoningMode(value); mode {
+	case "":
+		return defaultMode, nil
+	case ReasoningThink, ReasoningContent, ReasoningHidden:
+		return mode, nil
+	default:
+		return defaultMode, fmt.Errorf("invalid reasoning mode %q, expected think, reasoning_content or hidden", value)
+	}
 }
 
 type OpenAIResponse struct {
-	ID      string           `json:"id"`
-	Object  string           `json:"object"`
-	Created int64            `json:"created"`
-	Model   string           `json:"model"`
-	Choices []NoStreamChoice `json:"choices"`
-	Usage   Usage            `json:"usage"`
+	ID            string           `json:"id"`
+	Object        string           `json:"object"`
+	Created       int64            `json:"created"`
+	Model         string           `json:"model"`
+	Choices       []NoStreamChoice `json:"choices"`
+	Usage         Usage            `json:"usage"`
+	Citations     []string         `json:"citations,omitempty"`
+	SearchResults []SearchResult   `json:"search_results,omitempty"`
+	// DisplayModel is the model that actually answered when it differs from Model
+	DisplayModel string `json:"display_model,omitempty"`
 }
 type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
@@ -66,20 +106,284 @@ type Usage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
-func ReturnOpenAIResponse(text string, stream bool, gc *gin.Context) error {
-	if stream {
-		return streamRespose(text, gc)
-	} else {
-		return noStreamResponse(text, gc)
+// OpenAIOptions configures an OpenAIRenderer
+type OpenAIOptions struct {
+	Stream bool
+	// Model is the public model id the client requested
+	Model         string
+	ReasoningMode ReasoningMode
+	// PromptTokens is the estimated size of the built prompt
+	PromptTokens int
+	// IncludeUsage sends a usage chunk at the end of the stream
+	IncludeUsage bool
+	// Tools are the names of the functions the client offered, when set the answer
+	// is held back until it is known whether it is a tool call
+	Tools []string
+	// ResponseFormat asks for a JSON answer, the answer is validated before anything
+	// is sent so an invalid answer can still be retried on another session
+	ResponseFormat *ResponseFormat
+}
+
+// OpenAIRenderer renders the answer as an OpenAI chat completion,
+// the reasoning process is rendered according to the ReasoningMode
+type OpenAIRenderer struct {
+	gc           *gin.Context
+	opts         OpenAIOptions
+	id           string
+	created      int64
+	displayModel string
+	finishReason string
+	started      bool
+	inThinking   bool
+	thinkShown   bool
+	content      strings.Builder
+	reasoning    strings.Builder
+	completion   strings.Builder
+	answer       strings.Builder
+	toolCalls    []ToolCall
+	results      []SearchResult
+}
+
+func NewOpenAIRenderer(gc *gin.Context, opts OpenAIOptions) *OpenAIRenderer {
+	return &OpenAIRenderer{
+		gc:           gc,
+		opts:         opts,
+		id:           "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		created:      time.Now().Unix(),
+		finishReason: "stop",
+	}
+}
+
+func (r *OpenAIRenderer) Start() error {
+	if r.opts.Stream && !r.opts.ResponseFormat.JSON() {
+		r.startStream()
+	}
+	return nil
+}
+
+func (r *OpenAIRenderer) startStream() {
+	r.gc.Writer.Header().Set("Content-Type", "text/event-stream")
+	r.gc.Writer.Header().Set("Cache-Control", "no-cache")
+	r.gc.Writer.Header().Set("Connection", "keep-alive")
+	r.gc.Writer.WriteHeader(http.StatusOK)
+	r.gc.Writer.Flush()
+	r.started = true
+}
+
+func (r *OpenAIRenderer) Started() bool {
+	return r.started
+}
+
+func (r *OpenAIRenderer) Thinking(text string) error {
+	switch r.opts.ReasoningMode {
+	case ReasoningHidden:
+		return nil
+	case ReasoningContent:
+		if text == "" {
+			return nil
+		}
+		r.completion.WriteString(text)
+		if !r.opts.Stream || r.opts.ResponseFormat.JSON() {
+			r.reasoning.WriteString(text)
+			return nil
+		}
+		chunk := r.newChunk("")
+		chunk.Choices[0].Delta.ReasoningContent = text
+		return streamRespose(chunk, r.gc)
+	}
+	if !r.inThinking && !r.thinkShown {
+		text = "<think>" + text
+		r.inThinking = true
+	}
+	return r.write(text)
+}
+
+func (r *OpenAIRenderer) Text(text string) error {
+	prefix := ""
+	if r.inThinking {
+		prefix = "</think>\n\n"
+		r.inThinking = false
+		r.thinkShown = true
+	}
+	if r.holdsAnswer() {
+		r.answer.WriteString(text)
+		return r.write(prefix)
+	}
+	return r.write(prefix + text)
+}
+
+// SearchResults are returned as Sonar style citations and search_results
+func (r *OpenAIRenderer) SearchResults(results []SearchResult) {
+	r.results = append(r.results, results...)
+}
+
+// DisplayModel records the model that actually answered, model stays the requested one
+// in every chunk and the display model is sent with the final chunks
+func (r *OpenAIRenderer) DisplayModel(model string) {
+	if model != r.opts.Model {
+		r.displayModel = model
 	}
 }
 
-func streamRespose(text string, gc *gin.Context) error {
-	openAIResp := &OpenAISrteamResponse{
-		ID:      uuid.New().String(),
+// Stopped reports the finish_reason of an answer cut short
+func (r *OpenAIRenderer) Stopped(reason string, sequence string) {
+	r.finishReason = reason
+}
+
+func (r *OpenAIRenderer) Finish() error {
+	if r.inThinking {
+		r.write("</think>\n\n")
+		r.inThinking = false
+	}
+	if err := r.finishAnswer(); err != nil {
+		return err
+	}
+	r.gc.Set(UsageContextKey, r.usage())
+	if !r.opts.Stream {
+		return r.noStreamResponse()
+	}
+	// 最后一个分块返回 finish_reason 和搜索结果
+	chunk := r.newChunk("")
+	chunk.Choices[0].FinishReason = r.finishReason
+	chunk.DisplayModel = r.displayModel
+	if len(r.results) > 0 {
+		chunk.Citations = citations(r.results)
+		chunk.SearchResults = r.results
+	}
+	if err := streamRespose(chunk, r.gc); err != nil {
+		return err
+	}
+	if r.opts.IncludeUsage {
+		// stream_options.include_usage 要求额外发送一个 choices 为空的 usage 分块
+		chunk = r.newChunk("")
+		chunk.Choices = []StreamChoice{}
+		usage := r.usage()
+		chunk.Usage = &usage
+		chunk.DisplayModel = r.displayModel
+		if err := streamRespose(chunk, r.gc); err != nil {
+			return err
+		}
+	}
+	// Send end marker for streaming mode
+	r.gc.Writer.Write([]byte("data: [DONE]\n\n"))
+	r.gc.Writer.Flush()
+	return nil
+}
+
+// holdsAnswer reports whether the answer is held back until Finish
+func (r *OpenAIRenderer) holdsAnswer() bool {
+	return len(r.opts.Tools) > 0 || r.opts.ResponseFormat.JSON()
+}
+
+// finishAnswer parses the held back answer, a tool call is returned as tool_calls,
+// a JSON answer is validated and any other answer is written as content
+func (r *OpenAIRenderer) finishAnswer() error {
+	if !r.holdsAnswer() {
+		return nil
+	}
+	answer := r.answer.String()
+	if len(r.opts.Tools) > 0 {
+		r.toolCalls = ParseToolCalls(answer, r.opts.Tools)
+	}
+	if len(r.toolCalls) == 0 && r.opts.ResponseFormat.JSON() {
+		document, err := r.opts.ResponseFormat.Extract(answer)
+		if err != nil {
+			return err
+		}
+		answer = document
+	}
+	if err := r.startHeld(); err != nil {
+		return err
+	}
+	if len(r.toolCalls) == 0 {
+		return r.write(answer)
+	}
+	r.finishReason = "tool_calls"
+	for i := range r.toolCalls {
+		r.completion.WriteString(r.toolCalls[i].Function.Name + r.toolCalls[i].Function.Arguments)
+		if !r.opts.Stream {
+			continue
+		}
+		index := i
+		call := r.toolCalls[i]
+		call.Index = &index
+		chunk := r.newChunk("")
+		chunk.Choices[0].Delta.ToolCalls = []ToolCall{call}
+		if err := streamRespose(chunk, r.gc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startHeld starts a stream that was held back for validation and sends the held back reasoning
+func (r *OpenAIRenderer) startHeld() error {
+	if !r.opts.Stream || r.started {
+		return nil
+	}
+	r.startStream()
+	if r.reasoning.Len() == 0 {
+		return nil
+	}
+	chunk := r.newChunk("")
+	chunk.Choices[0].Delta.ReasoningContent = r.reasoning.String()
+	return streamRespose(chunk, r.gc)
+}
+
+func (r *OpenAIRenderer) Error(status int, code string, message string) {
+	if !r.started {
+		r.gc.JSON(status, NewOpenAIError(status, code, message))
+		return
+	}
+	// 流式响应已经开始，只能在流中返回错误事件
+	jsonBytes, err := json.Marshal(NewOpenAIError(status, code, message))
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error marshalling JSON: %v", err))
+		return
+	}
+	r.gc.Writer.Write([]byte("data: " + string(jsonBytes) + "\n\n"))
+	r.gc.Writer.Write([]byte("data: [DONE]\n\n"))
+	r.gc.Writer.Flush()
+}
+
+func (r *OpenAIRenderer) write(text string) error {
+	if text == "" {
+		return nil
+	}
+	r.completion.WriteString(text)
+	if !r.opts.Stream {
+		r.content.WriteString(text)
+		return nil
+	}
+	return streamRespose(r.newChunk(text), r.gc)
+}
+
+// usage estimates the token usage of the prompt and everything written so far
+func (r *OpenAIRenderer) usage() Usage {
+	completionTokens := tokenizer.Count(r.completion.String())
+	return Usage{
+		PromptTokens:     r.opts.PromptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      r.opts.PromptTokens + completionTokens,
+	}
+}
+
+// citations returns the URLs of the search results
+func citations(results []SearchResult) []string {
+	urls := make([]string, 0, len(results))
+	for _, result := range results {
+		urls = append(urls, result.URL)
+	}
+	return urls
+}
+
+// newChunk creates a chunk sharing the completion id of the stream
+func (r *OpenAIRenderer) newChunk(text string) *OpenAISrteamResponse {
+	return &OpenAISrteamResponse{
+		ID:      r.id,
 		Object:  "chat.completion.chunk",
-		Created: time.Now().Unix(),
-		Model:   "claude-3-7-sonnet-20250219",
+		Created: r.created,
+		Model:   r.opts.Model,
 		Choices: []StreamChoice{
 			{
 				Index: 0,
@@ -91,7 +395,9 @@ func streamRespose(text string, gc *gin.Context) error {
 			},
 		},
 	}
+}
 
+func streamRespose(openAIResp *OpenAISrteamResponse, gc *gin.Context) error {
 	jsonBytes, err := json.Marshal(openAIResp)
 	jsonBytes = append([]byte("data: "), jsonBytes...)
 	jsonBytes = append(jsonBytes, []byte("\n\n")...)
@@ -106,25 +412,33 @@ func streamRespose(text string, gc *gin.Context) error {
 	return nil
 }
 
-func noStreamResponse(text string, gc *gin.Context) error {
+func (r *OpenAIRenderer) noStreamResponse() error {
 	openAIResp := &OpenAIResponse{
-		ID:      uuid.New().String(),
+		ID:      r.id,
 		Object:  "chat.completion",
-		Created: time.Now().Unix(),
-		Model:   "claude-3-7-sonnet-20250219",
+		Created: r.created,
+		Model:   r.opts.Model,
 		Choices: []NoStreamChoice{
 			{
 				Index: 0,
 				Message: Message{
-					Role:    "assistant",
-					Content: text,
+					Role:             "assistant",
+					Content:          r.content.String(),
+					ReasoningContent: r.reasoning.String(),
+					ToolCalls:        r.toolCalls,
 				},
 				Logprobs:     nil,
-				FinishReason: "stop",
+				FinishReason: r.finishReason,
 			},
 		},
+		Usage:        r.usage(),
+		DisplayModel: r.displayModel,
+	}
+	if len(r.results) > 0 {
+		openAIResp.Citations = citations(r.results)
+		openAIResp.SearchResults = r.results
 	}
 
-	gc.JSON(200, openAIResp)
+	r.gc.JSON(200, openAIResp)
 	return nil
 }
@@ -0,0 +1,45 @@
+package model
+
+// Renderer renders the upstream answer in the format of a client API.
+// A new Renderer is used for every upstream attempt.
+type Renderer interface {
+	// Start writes the response headers and opening events of a streaming response
+	Start() error
+	// Thinking renders a piece of the reasoning process
+	Thinking(text string) error
+	// Text renders a piece of the answer
+	Text(text string) error
+	// SearchResults records the web results the answer is based on
+	SearchResults(results []SearchResult)
+	// DisplayModel reports the public id of the model that actually answered
+	DisplayModel(model string)
+	// Stopped reports that the answer was cut short, reason is FinishStop with the
+	// matched stop sequence or FinishLength when the token limit was reached
+	Stopped(reason string, sequence string)
+	// Finish completes the response
+	Finish() error
+	// Started reports whether the response has been committed to the client,
+	// after which the request can no longer be retried on another session
+	Started() bool
+	// Error reports a failure, as an error response before the response started
+	// or as an error event inside the stream afterwards
+	Error(status int, code string, message string)
+}
+
+const (
+	// FinishStop 回答遇到停止序列
+	FinishStop = "stop"
+	// FinishLength 回答达到 max_tokens
+	FinishLength = "length"
+)
+
+// UsageContextKey is the gin context key under which a finished response
+// stores its Usage, so middlewares can account the tokens of the request
+const UsageContextKey = "usage"
+
+// SearchResult is a web result returned by a -search model
+type SearchResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+}
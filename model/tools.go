@@ -0,0 +1,153 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"pplx2api/utils"
+)
+
+// ToolCallsFence is the info string of the fenced block the model writes its tool calls in
+const ToolCallsFence = "tool_calls"
+
+// ToolCall is an OpenAI function call made by the assistant
+type ToolCall struct {
+	// Index is only set on stream deltas
+	Index    *int             `json:"index,omitempty"`
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name string `json:"name"`
+	// Arguments is a JSON encoded object
+	Arguments string `json:"arguments"`
+}
+
+// promptToolCall is the shape of a tool call written in the prompt and the answer
+type promptToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// FormatToolCalls renders tool calls as the fenced block the model is asked to write
+func FormatToolCalls(calls []ToolCall) string {
+	items := make([]promptToolCall, 0, len(calls))
+	for _, call := range calls {
+		arguments := json.RawMessage(call.Function.Arguments)
+		if !json.Valid(arguments) {
+			arguments, _ = json.Marshal(call.Function.Arguments)
+		}
+		items = append(items, promptToolCall{Name: call.Function.Name, Arguments: arguments})
+	}
+	data, _ := json.Marshal(items)
+	return "```" + ToolCallsFence + "\n" + string(data) + "\n```"
+}
+
+var fencedBlock = regexp.MustCompile("(?s)```([A-Za-z_]*)[^\\n]*\\n(.*?)```")
+
+// ParseToolCalls extracts the tool calls from the answer. Calls of functions that are
+// not in names are ignored, nil is returned when the answer contains no valid call.
+func ParseToolCalls(text string, names []string) []ToolCall {
+	var candidates []string
+	var fallbacks []string
+	for _, match := range fencedBlock.FindAllStringSubmatch(text, -1) {
+		if match[1] == ToolCallsFence {
+			candidates = append(candidates, match[2])
+		} else if match[1] == "" || match[1] == "json" {
+			fallbacks = append(fallbacks, match[2])
+		}
+	}
+	// 模型没有使用代码块时，尝试解析整个回答
+	fallbacks = append(fallbacks, text)
+	for _, candidate := range append(candidates, fallbacks...) {
+		if calls := decodeToolCalls(candidate, names); len(calls) > 0 {
+			return calls
+		}
+	}
+	return nil
+}
+
+// decodeToolCalls decodes an array of calls, a single call or an object with a tool_calls array
+func decodeToolCalls(text string, names []string) []ToolCall {
+	text = strings.TrimSpace(text)
+	if text == "" || (text[0] != '[' && text[0] != '{') {
+		return nil
+	}
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text), &items); err != nil {
+		var item map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(text), &item); err != nil {
+			return nil
+		}
+		if nested, ok := item["tool_calls"]; ok {
+			if err := json.Unmarshal(nested, &items); err != nil {
+				return nil
+			}
+		} else {
+			items = []map[string]json.RawMessage{item}
+		}
+	}
+	var calls []ToolCall
+	for _, item := range items {
+		// OpenAI 格式的调用把名称和参数放在 function 中
+		if function, ok := item["function"]; ok {
+			var nested map[string]json.RawMessage
+			if json.Unmarshal(function, &nested) == nil {
+				item = nested
+			}
+		}
+		var name string
+		if err := json.Unmarshal(item["name"], &name); err != nil || !knownTool(name, names) {
+			continue
+		}
+		arguments := item["arguments"]
+		if arguments == nil {
+			arguments = item["parameters"]
+		}
+		calls = append(calls, ToolCall{
+			ID:   "call_" + utils.RandomString(24),
+			Type: "function",
+			Function: ToolCallFunction{
+				Name:      name,
+				Arguments: encodeArguments(arguments),
+			},
+		})
+	}
+	return calls
+}
+
+// encodeArguments returns the arguments as a JSON encoded object
+func encodeArguments(arguments json.RawMessage) string {
+	if len(arguments) == 0 || string(arguments) == "null" {
+		return "{}"
+	}
+	// 参数已经是 JSON 字符串时直接使用其内容
+	var encoded string
+	if json.Unmarshal(arguments, &encoded) == nil && json.Valid([]byte(encoded)) {
+		return encoded
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, arguments); err != nil {
+		return string(arguments)
+	}
+	return compact.String()
+}
+
+func knownTool(name string, names []string) bool {
+	if name == "" {
+		return false
+	}
+	if len(names) == 0 {
+		return true
+	}
+	for _, known := range names {
+		if known == name {
+			return true
+		}
+	}
+	return false
+}
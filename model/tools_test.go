@@ -0,0 +1,110 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseToolCalls(t *testing.T) {
+	names := []string{"get_weather", "search"}
+	tests := []struct {
+		name string
+		text string
+		// want are the names and arguments of the parsed calls
+		want [][2]string
+	}{
+		{
+			name: "tool_calls fence",
+			text: "Let me check.\n```tool_calls\n[{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}]\n```",
+			want: [][2]string{{"get_weather", `{"city":"Paris"}`}},
+		},
+		{
+			name: "several calls",
+			text: "```tool_calls\n[{\"name\":\"get_weather\",\"arguments\":{\"city\":\"Paris\"}},{\"name\":\"search\",\"arguments\":{\"q\":\"news\"}}]\n```",
+			want: [][2]string{{"get_weather", `{"city":"Paris"}`}, {"search", `{"q":"news"}`}},
+		},
+		{
+			name: "json fence with a single call",
+			text: "```json\n{\"name\": \"search\", \"arguments\": {\"q\": \"go\"}}\n```",
+			want: [][2]string{{"search", `{"q":"go"}`}},
+		},
+		{
+			name: "unfenced object with tool_calls",
+			text: `{"tool_calls": [{"name": "search", "parameters": {"q": "go"}}]}`,
+			want: [][2]string{{"search", `{"q":"go"}`}},
+		},
+		{
+			name: "openai shape with string arguments",
+			text: "```tool_calls\n[{\"type\":\"function\",\"function\":{\"name\":\"search\",\"arguments\":\"{\\\"q\\\":\\\"go\\\"}\"}}]\n```",
+			want: [][2]string{{"search", `{"q":"go"}`}},
+		},
+		{
+			name: "missing arguments",
+			text: "```tool_calls\n[{\"name\":\"search\"}]\n```",
+			want: [][2]string{{"search", `{}`}},
+		},
+		{
+			name: "tool_calls fence wins over other blocks",
+			text: "```json\n{\"name\":\"get_weather\",\"arguments\":{}}\n```\n```tool_calls\n[{\"name\":\"search\",\"arguments\":{}}]\n```",
+			want: [][2]string{{"search", `{}`}},
+		},
+		{
+			name: "unknown tools are ignored",
+			text: "```tool_calls\n[{\"name\":\"delete_all\",\"arguments\":{}},{\"name\":\"search\",\"arguments\":{\"q\":\"x\"}}]\n```",
+			want: [][2]string{{"search", `{"q":"x"}`}},
+		},
+		{
+			name: "only unknown tools",
+			text: "```tool_calls\n[{\"name\":\"delete_all\",\"arguments\":{}}]\n```",
+		},
+		{
+			name: "plain answer",
+			text: "The weather in Paris is sunny.",
+		},
+		{
+			name: "code that is not a tool call",
+			text: "```json\n{\"city\": \"Paris\"}\n```",
+		},
+		{
+			name: "invalid json",
+			text: "```tool_calls\n[{\"name\": \"search\", \n```",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := ParseToolCalls(tt.text, names)
+			if len(calls) != len(tt.want) {
+				t.Fatalf("ParseToolCalls() = %+v, want %d calls", calls, len(tt.want))
+			}
+			for i, call := range calls {
+				if call.Function.Name != tt.want[i][0] || call.Function.Arguments != tt.want[i][1] {
+					t.Errorf("call %d = %s(%s), want %s(%s)", i, call.Function.Name, call.Function.Arguments, tt.want[i][0], tt.want[i][1])
+				}
+				if call.Type != "function" || !strings.HasPrefix(call.ID, "call_") {
+					t.Errorf("call %d has type %q and id %q", i, call.Type, call.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatToolCallsRoundTrip(t *testing.T) {
+	calls := []ToolCall{
+		{ID: "call_1", Type: "function", Function: ToolCallFunction{Name: "search", Arguments: `{"q": "go"}`}},
+		{ID: "call_2", Type: "function", Function: ToolCallFunction{Name: "get_weather", Arguments: `not json`}},
+	}
+	text := FormatToolCalls(calls)
+	if !strings.HasPrefix(text, "```"+ToolCallsFence+"\n") {
+		t.Fatalf("FormatToolCalls() = %q, want a %s fence", text, ToolCallsFence)
+	}
+	parsed := ParseToolCalls(text, nil)
+	want := [][2]string{{"search", `{"q":"go"}`}, {"get_weather", `"not json"`}}
+	if len(parsed) != len(want) {
+		t.Fatalf("ParseToolCalls() = %+v, want %d calls", parsed, len(want))
+	}
+	for i, call := range parsed {
+		if call.Function.Name != want[i][0] || call.Function.Arguments != want[i][1] {
+			t.Errorf("call %d = %s(%s), want %s(%s)", i, call.Function.Name, call.Function.Arguments, want[i][0], want[i][1])
+		}
+	}
+}
@@ -1,30 +1,67 @@
 package router
 
 import (
+	"pplx2api/config"
+	"pplx2api/job"
+	"pplx2api/metrics"
 	"pplx2api/middleware"
 	"pplx2api/service"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine) {
+func SetupRoutes(r *gin.Engine, sessionUpdater *job.SessionUpdater) {
 	// Apply middleware
-	r.Use(middleware.CORSMiddleware())
-	r.Use(middleware.AuthMiddleware())
+	r.Use(middleware.RequestIDMiddleware(), middleware.LoggerMiddleware(), middleware.CORSMiddleware())
 
-	// Health check endpoint
-	r.GET("/health", service.HealthCheckHandler)
+	// Prometheus metrics, protected by the metrics key when it is set
+	r.GET("/metrics", middleware.MetricsAuthMiddleware(), metrics.Handler)
 
-	// Chat completions endpoint (OpenAI-compatible)
-	r.POST("/v1/chat/completions", service.ChatCompletionsHandler)
-	r.GET("/v1/models", service.MoudlesHandler)
-	// HuggingFace compatible routes
-	hfRouter := r.Group("/hf")
+	// Admin endpoints, protected by the admin key
+	admin := service.NewAdminHandler(sessionUpdater)
+	adminRouter := r.Group("/admin", middleware.AdminAuthMiddleware())
 	{
-		v1Router := hfRouter.Group("/v1")
+		adminRouter.GET("/sessions", admin.ListSessions)
+		adminRouter.POST("/sessions", admin.AddSession)
+		adminRouter.POST("/sessions/refresh", admin.RefreshAllSessions)
+		adminRouter.DELETE("/sessions/:id", admin.RemoveSession)
+		adminRouter.POST("/sessions/:id/disable", admin.DisableSession)
+		adminRouter.POST("/sessions/:id/enable", admin.EnableSession)
+		adminRouter.POST("/sessions/:id/refresh", admin.RefreshSession)
+		adminRouter.GET("/config/reloads", admin.ListReloads)
+		adminRouter.POST("/config/reload", admin.ReloadConfig)
+		adminRouter.GET("/files", admin.ListFiles)
+	}
+
+	// API endpoints, rate limited per client before authentication
+	limiter := middleware.NewRateLimiter(config.Current().RateLimitRPM, config.Current().RateLimitStreams)
+	config.OnReload(func(c *config.Config) {
+		limiter.SetLimits(c.RateLimitRPM, c.RateLimitStreams)
+	})
+	apiRouter := r.Group("/", middleware.MetricsMiddleware(), middleware.RateLimitMiddleware(limiter), middleware.AuthMiddleware())
+	{
+		// Health check endpoint
+		apiRouter.GET("/health", service.HealthCheckHandler)
+
+		// Chat completions endpoint (OpenAI-compatible)
+		apiRouter.POST("/v1/chat/completions", service.ChatCompletionsHandler)
+		apiRouter.GET("/v1/models", service.MoudlesHandler)
+		// Messages endpoint (Anthropic-compatible)
+		apiRouter.POST("/v1/messages", service.MessagesHandler)
+		// Files endpoints (OpenAI-compatible), referenced by file_id in chat completions
+		apiRouter.POST("/v1/files", service.UploadFileHandler)
+		apiRouter.GET("/v1/files", service.ListFilesHandler)
+		apiRouter.GET("/v1/files/:id", service.RetrieveFileHandler)
+		apiRouter.GET("/v1/files/:id/content", service.RetrieveFileContentHandler)
+		apiRouter.DELETE("/v1/files/:id", service.DeleteFileHandler)
+		// HuggingFace compatible routes
+		hfRouter := apiRouter.Group("/hf")
 		{
-			v1Router.POST("/chat/completions", service.ChatCompletionsHandler)
-			v1Router.GET("/models", service.MoudlesHandler)
+			v1Router := hfRouter.Group("/v1")
+			{
+				v1Router.POST("/chat/completions", service.ChatCompletionsHandler)
+				v1Router.GET("/models", service.MoudlesHandler)
+			}
 		}
 	}
 }
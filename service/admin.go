@@ -0,0 +1,194 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"pplx2api/config"
+	"pplx2api/job"
+	"pplx2api/logger"
+	"pplx2api/model"
+	"pplx2api/store"
+	"pplx2api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler serves the session management endpoints
+type AdminHandler struct {
+	updater *job.SessionUpdater
+}
+
+// NewAdminHandler creates an admin handler persisting changes through updater
+func NewAdminHandler(updater *job.SessionUpdater) *AdminHandler {
+	return &AdminHandler{
+		updater: updater,
+	}
+}
+
+type AddSessionRequest struct {
+	SessionKey string `json:"session_key"`
+}
+
+// SessionView is the masked representation of a session
+type SessionView struct {
+	ID         string               `json:"id"`
+	SessionKey string               `json:"session_key"`
+	Disabled   bool                 `json:"disabled"`
+	Health     config.SessionHealth `json:"health"`
+}
+
+func newSessionView(session config.SessionInfo) SessionView {
+	return SessionView{
+		ID:         config.SessionID(session.SessionKey),
+		SessionKey: utils.MaskSecret(session.SessionKey),
+		Disabled:   session.Disabled,
+		Health:     config.Health.Get(session.SessionKey),
+	}
+}
+
+// save persists the sessions to the file the SessionUpdater writes
+func (h *AdminHandler) save(c *gin.Context) bool {
+	if err := h.updater.SaveSessions(); err != nil {
+		logger.Error(fmt.Sprintf("Failed to save sessions: %v", err))
+		model.AbortWithOpenAIError(c, http.StatusInternalServerError, "save_failed", fmt.Sprintf("Failed to save sessions: %v", err))
+		return false
+	}
+	return true
+}
+
+// findSession looks up the session referenced by the :id path parameter
+func (h *AdminHandler) findSession(c *gin.Context) (config.SessionInfo, bool) {
+	session, ok := config.Current().FindSession(c.Param("id"))
+	if !ok {
+		model.AbortWithOpenAIError(c, http.StatusNotFound, "session_not_found", "Session not found")
+	}
+	return session, ok
+}
+
+// ListFiles lists the files uploaded through the Files API by every API key
+func (h *AdminHandler) ListFiles(c *gin.Context) {
+	files, err := store.Files.List(store.AllOwners, c.Query("purpose"))
+	if err != nil {
+		abortWithFileError(c, "", err)
+		return
+	}
+	c.JSON(http.StatusOK, model.FileList{
+		Object: "list",
+		Data:   files,
+	})
+}
+
+// ListSessions lists all sessions with masked keys
+func (h *AdminHandler) ListSessions(c *gin.Context) {
+	sessions := config.Current().SessionsSnapshot()
+	views := make([]SessionView, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, newSessionView(session))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": views,
+	})
+}
+
+// AddSession adds a new session to the rotation
+func (h *AdminHandler) AddSession(c *gin.Context) {
+	var req AddSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	if err := config.Current().AddSession(req.SessionKey); err != nil {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "invalid_session", err.Error())
+		return
+	}
+	if !h.save(c) {
+		return
+	}
+	logger.Info(fmt.Sprintf("Session %s added", config.SessionID(req.SessionKey)))
+	c.JSON(http.StatusCreated, newSessionView(config.SessionInfo{SessionKey: req.SessionKey}))
+}
+
+// RemoveSession removes a session from the rotation
+func (h *AdminHandler) RemoveSession(c *gin.Context) {
+	session, ok := h.findSession(c)
+	if !ok {
+		return
+	}
+	if err := config.Current().RemoveSession(session.SessionKey); err != nil {
+		model.AbortWithOpenAIError(c, http.StatusNotFound, "session_not_found", err.Error())
+		return
+	}
+	if !h.save(c) {
+		return
+	}
+	logger.Info(fmt.Sprintf("Session %s removed", c.Param("id")))
+	c.Status(http.StatusNoContent)
+}
+
+// DisableSession takes a session out of the rotation
+func (h *AdminHandler) DisableSession(c *gin.Context) {
+	h.setDisabled(c, true)
+}
+
+// EnableSession puts a disabled session back into the rotation
+func (h *AdminHandler) EnableSession(c *gin.Context) {
+	h.setDisabled(c, false)
+}
+
+func (h *AdminHandler) setDisabled(c *gin.Context, disabled bool) {
+	session, ok := h.findSession(c)
+	if !ok {
+		return
+	}
+	if err := config.Current().SetSessionDisabled(session.SessionKey, disabled); err != nil {
+		model.AbortWithOpenAIError(c, http.StatusNotFound, "session_not_found", err.Error())
+		return
+	}
+	if !disabled {
+		// 重新启用时清除之前的失败记录
+		config.Health.MarkHealthy(session.SessionKey)
+	}
+	if !h.save(c) {
+		return
+	}
+	logger.Info(fmt.Sprintf("Session %s disabled: %t", c.Param("id"), disabled))
+	session.Disabled = disabled
+	c.JSON(http.StatusOK, newSessionView(session))
+}
+
+// RefreshSession forces a cookie refresh for one session
+func (h *AdminHandler) RefreshSession(c *gin.Context) {
+	session, ok := h.findSession(c)
+	if !ok {
+		return
+	}
+	newKey, err := h.updater.RefreshSession(session.SessionKey)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Failed to refresh session %s: %v", c.Param("id"), err))
+		model.AbortWithOpenAIError(c, http.StatusBadGateway, "refresh_failed", fmt.Sprintf("Failed to refresh session: %v", err))
+		return
+	}
+	session.SessionKey = newKey
+	c.JSON(http.StatusOK, newSessionView(session))
+}
+
+// RefreshAllSessions forces a cookie refresh for every session
+func (h *AdminHandler) RefreshAllSessions(c *gin.Context) {
+	h.updater.RefreshAll()
+	h.ListSessions(c)
+}
+
+// ListReloads returns the results of the latest config reloads
+func (h *AdminHandler) ListReloads(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": config.ReloadResults()})
+}
+
+// ReloadConfig reloads the config file, keys file and model map
+func (h *AdminHandler) ReloadConfig(c *gin.Context) {
+	result := config.Reload("admin")
+	if !result.Success {
+		model.AbortWithOpenAIError(c, http.StatusInternalServerError, "reload_failed", fmt.Sprintf("Failed to reload config: %s", result.Error))
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
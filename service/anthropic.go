@@ -0,0 +1,144 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pplx2api/model"
+	"pplx2api/tokenizer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnthropicMessagesRequest is the body of an Anthropic /v1/messages request
+type AnthropicMessagesRequest struct {
+	Model         string                   `json:"model"`
+	System        interface{}              `json:"system,omitempty"`
+	Messages      []map[string]interface{} `json:"messages"`
+	MaxTokens     int                      `json:"max_tokens,omitempty"`
+	StopSequences []string                 `json:"stop_sequences,omitempty"`
+	Stream        bool                     `json:"stream"`
+}
+
+// MessagesHandler handles the Anthropic messages endpoint with the real Perplexity client
+func MessagesHandler(c *gin.Context) {
+	defaultHandler.Messages(c)
+}
+
+// Messages handles the Anthropic compatible messages endpoint
+func (h *Handler) Messages(c *gin.Context) {
+	renderer := model.NewAnthropicRenderer(c, false, "", 0)
+	var req AnthropicMessagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		renderer.Error(http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid request: %v", err))
+		return
+	}
+	if len(req.Messages) == 0 {
+		renderer.Error(http.StatusBadRequest, "missing_messages", "No messages provided")
+		return
+	}
+
+	cfg := requestConfig(c)
+	pplxModel, openSearch := parseModel(cfg, req.Model)
+	prompt, img_data_list, files := buildPrompt(cfg, anthropicToOpenAIMessages(req))
+	promptTokens := tokenizer.Count(prompt)
+	h.complete(c, pplxModel, openSearch, prompt, img_data_list, files, func() model.Renderer {
+		renderer := model.NewAnthropicRenderer(c, req.Stream, publicModel(req.Model), promptTokens)
+		return model.NewLimitRenderer(renderer, model.Limits{Stop: req.StopSequences, MaxTokens: req.MaxTokens})
+	})
+}
+
+// anthropicToOpenAIMessages converts the system field and content blocks
+// into OpenAI style messages so the same prompt building is used
+func anthropicToOpenAIMessages(req AnthropicMessagesRequest) []map[string]interface{} {
+	messages := []map[string]interface{}{}
+	switch system := req.System.(type) {
+	case string:
+		if system != "" {
+			messages = append(messages, map[string]interface{}{"role": "system", "content": system})
+		}
+	case []interface{}:
+		messages = append(messages, map[string]interface{}{"role": "system", "content": anthropicToOpenAIContent(system)})
+	}
+	for _, msg := range req.Messages {
+		role, ok := msg["role"].(string)
+		if !ok {
+			continue
+		}
+		switch content := msg["content"].(type) {
+		case string:
+			messages = append(messages, map[string]interface{}{"role": role, "content": content})
+		case []interface{}:
+			messages = append(messages, map[string]interface{}{"role": role, "content": anthropicToOpenAIContent(content)})
+		}
+	}
+	return messages
+}
+
+// anthropicToOpenAIContent converts Anthropic content blocks into OpenAI content parts
+func anthropicToOpenAIContent(blocks []interface{}) []interface{} {
+	parts := []interface{}{}
+	for _, item := range blocks {
+		block, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		blockType, _ := block["type"].(string)
+		switch blockType {
+		case "text":
+			if text, ok := block["text"].(string); ok {
+				parts = append(parts, map[string]interface{}{"type": "text", "text": text})
+			}
+		case "image":
+			source, ok := block["source"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			url := ""
+			switch source["type"] {
+			case "base64":
+				mediaType, _ := source["media_type"].(string)
+				data, _ := source["data"].(string)
+				url = fmt.Sprintf("data:%s;base64,%s", mediaType, data)
+			case "url":
+				url, _ = source["url"].(string)
+			}
+			if url != "" {
+				parts = append(parts, map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": url}})
+			}
+		case "document":
+			// base64 和纯文本文档转换为 OpenAI 的 file 内容
+			source, ok := block["source"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			title, _ := block["title"].(string)
+			data := ""
+			switch source["type"] {
+			case "base64":
+				mediaType, _ := source["media_type"].(string)
+				encoded, _ := source["data"].(string)
+				data = fmt.Sprintf("data:%s;base64,%s", mediaType, encoded)
+			case "text":
+				text, _ := source["data"].(string)
+				data = "data:text/plain;base64," + base64.StdEncoding.EncodeToString([]byte(text))
+			}
+			if data != "" {
+				parts = append(parts, map[string]interface{}{"type": "file", "file": map[string]interface{}{"filename": title, "file_data": data}})
+			}
+		case "tool_use":
+			input, _ := json.Marshal(block["input"])
+			parts = append(parts, map[string]interface{}{"type": "text", "text": fmt.Sprintf("Tool call %v: %s", block["name"], input)})
+		case "tool_result":
+			switch result := block["content"].(type) {
+			case string:
+				parts = append(parts, map[string]interface{}{"type": "text", "text": "Tool result: " + result})
+			case []interface{}:
+				parts = append(parts, map[string]interface{}{"type": "text", "text": "Tool result:"})
+				parts = append(parts, anthropicToOpenAIContent(result)...)
+			}
+		}
+	}
+	return parts
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"pplx2api/config"
+	"pplx2api/core"
+	"pplx2api/logger"
+	"pplx2api/middleware"
+	"pplx2api/model"
+	"pplx2api/store"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fileInput is a file content part, given inline as file_data or by file_id
+type fileInput struct {
+	Filename string
+	Data     string
+	FileID   string
+}
+
+// parseFilePart reads the file of an OpenAI file content part
+func parseFilePart(part map[string]interface{}) fileInput {
+	file, _ := part["file"].(map[string]interface{})
+	var input fileInput
+	input.Filename, _ = file["filename"].(string)
+	input.Data, _ = file["file_data"].(string)
+	input.FileID, _ = file["file_id"].(string)
+	return input
+}
+
+// resolveFiles decodes the file content parts and loads the files referenced by file_id,
+// images among them are returned as data URLs so they are uploaded like image_url parts
+func resolveFiles(files []fileInput, owner string) ([]string, []core.Document, error) {
+	var images []string
+	var documents []core.Document
+	for i, file := range files {
+		var doc *core.Document
+		var err error
+		switch {
+		case file.FileID != "":
+			doc, err = storedDocument(file.FileID, owner)
+		case file.Data != "":
+			doc, err = core.NewDocument(file.Filename, file.Data)
+		default:
+			err = fmt.Errorf("file_data or file_id is required")
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("file %d: %v", i, err)
+		}
+		if doc.IsImage() {
+			images = append(images, doc.DataURL())
+			continue
+		}
+		documents = append(documents, *doc)
+	}
+	return images, documents, nil
+}
+
+// storedDocument loads a file uploaded through the Files API
+func storedDocument(id string, owner string) (*core.Document, error) {
+	object, contentType, data, err := store.Files.Content(owner, id)
+	if errors.Is(err, store.ErrFileNotFound) {
+		return nil, fmt.Errorf("no such file: %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return core.NewDocumentFromData(object.Filename, contentType, data)
+}
+
+// fileOwner identifies the API key of the request, files are only visible to the key
+// that uploaded them, including the APIKEY key; every file is only listed by the admin API
+func fileOwner(c *gin.Context) string {
+	key := requestConfig(c).APIKey
+	if value, ok := c.Get(middleware.APIKeyContextKey); ok {
+		key = value.(config.APIKeyInfo).Key
+	}
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:8])
+}
+
+// abortWithFileError renders a file store error
+func abortWithFileError(c *gin.Context, id string, err error) {
+	if errors.Is(err, store.ErrFileNotFound) {
+		model.AbortWithOpenAIError(c, http.StatusNotFound, "file_not_found", fmt.Sprintf("No such file: %s", id))
+		return
+	}
+	logger.Error(fmt.Sprintf("File store error: %v", err))
+	model.AbortWithOpenAIError(c, http.StatusInternalServerError, "file_store_error", err.Error())
+}
+
+// UploadFileHandler stores a file uploaded as multipart form data
+func UploadFileHandler(c *gin.Context) {
+	cfg := requestConfig(c)
+	maxBytes := int64(cfg.FilesMaxMB) << 20
+	// 预留表单其他字段的空间，文件本身的大小在下面单独检查
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+1<<20)
+	purpose := c.PostForm("purpose")
+	if !slices.Contains(model.FilePurposes, purpose) {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "invalid_purpose", fmt.Sprintf("purpose must be one of %s", strings.Join(model.FilePurposes, ", ")))
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			model.AbortWithOpenAIError(c, http.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("File exceeds %d MB", cfg.FilesMaxMB))
+			return
+		}
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "missing_file", fmt.Sprintf("Invalid file: %v", err))
+		return
+	}
+	if header.Size > maxBytes {
+		model.AbortWithOpenAIError(c, http.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("File exceeds %d MB", cfg.FilesMaxMB))
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "missing_file", fmt.Sprintf("Invalid file: %v", err))
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "missing_file", fmt.Sprintf("Invalid file: %v", err))
+		return
+	}
+	if len(data) == 0 {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "empty_file", "File is empty")
+		return
+	}
+	// 检查文件能否作为附件上传，同时规范文件名
+	doc, err := core.NewDocumentFromData(header.Filename, header.Header.Get("Content-Type"), data)
+	if err != nil {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "invalid_file", err.Error())
+		return
+	}
+	object, err := store.Files.Create(fileOwner(c), doc.Filename, doc.ContentType, purpose, data)
+	if err != nil {
+		abortWithFileError(c, "", err)
+		return
+	}
+	logger.Info(fmt.Sprintf("File %s stored: %s (%s, %d bytes)", object.ID, object.Filename, doc.ContentType, object.Bytes))
+	c.JSON(http.StatusOK, object)
+}
+
+// ListFilesHandler lists the stored files of the API key
+func ListFilesHandler(c *gin.Context) {
+	files, err := store.Files.List(fileOwner(c), c.Query("purpose"))
+	if err != nil {
+		abortWithFileError(c, "", err)
+		return
+	}
+	c.JSON(http.StatusOK, model.FileList{
+		Object: "list",
+		Data:   files,
+	})
+}
+
+// RetrieveFileHandler returns the file object of a stored file
+func RetrieveFileHandler(c *gin.Context) {
+	object, err := store.Files.Get(fileOwner(c), c.Param("id"))
+	if err != nil {
+		abortWithFileError(c, c.Param("id"), err)
+		return
+	}
+	c.JSON(http.StatusOK, object)
+}
+
+// RetrieveFileContentHandler returns the content of a stored file
+func RetrieveFileContentHandler(c *gin.Context) {
+	_, contentType, data, err := store.Files.Content(fileOwner(c), c.Param("id"))
+	if err != nil {
+		abortWithFileError(c, c.Param("id"), err)
+		return
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// DeleteFileHandler removes a stored file
+func DeleteFileHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := store.Files.Delete(fileOwner(c), id); err != nil {
+		abortWithFileError(c, id, err)
+		return
+	}
+	logger.Info(fmt.Sprintf("File %s deleted", id))
+	c.JSON(http.StatusOK, model.FileDeleted{
+		ID:      id,
+		Object:  "file",
+		Deleted: true,
+	})
+}
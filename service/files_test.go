@@ -0,0 +1,113 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"pplx2api/config"
+	"pplx2api/middleware"
+	"pplx2api/model"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// filesRouter serves the Files API and the admin file list with a legacy APIKEY and a key from the keys file
+func filesRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	previous := config.Current()
+	t.Cleanup(func() { config.Store(previous) })
+	cfg := previous.Clone()
+	cfg.FilesDir = t.TempDir()
+	cfg.APIKey = "legacy-key"
+	cfg.AdminKey = "admin-key"
+	cfg.APIKeys = []config.APIKeyInfo{{Key: "project-key", Label: "project"}}
+	config.Store(cfg)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/files", middleware.AuthMiddleware(), UploadFileHandler)
+	r.GET("/v1/files", middleware.AuthMiddleware(), ListFilesHandler)
+	r.GET("/v1/files/:id", middleware.AuthMiddleware(), RetrieveFileHandler)
+	r.DELETE("/v1/files/:id", middleware.AuthMiddleware(), DeleteFileHandler)
+	r.GET("/admin/files", middleware.AdminAuthMiddleware(), NewAdminHandler(nil).ListFiles)
+	return r
+}
+
+func filesRequest(t *testing.T, r *gin.Engine, method string, path string, key string, filename string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	req := httptest.NewRequest(method, path, nil)
+	if filename != "" {
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("purpose", "user_data")
+		part, _ := writer.CreateFormFile("file", filename)
+		part.Write([]byte("content of " + filename))
+		writer.Close()
+		req = httptest.NewRequest(method, path, &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestFilesAreIsolatedBetweenKeys(t *testing.T) {
+	r := filesRouter(t)
+	uploads := map[string]model.FileObject{}
+	for _, key := range []string{"legacy-key", "project-key"} {
+		w := filesRequest(t, r, http.MethodPost, "/v1/files", key, key+".txt")
+		if w.Code != http.StatusOK {
+			t.Fatalf("upload with %s: status = %d: %s", key, w.Code, w.Body.String())
+		}
+		var object model.FileObject
+		if err := json.Unmarshal(w.Body.Bytes(), &object); err != nil {
+			t.Fatal(err)
+		}
+		uploads[key] = object
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		key        string
+		file       string
+		wantStatus int
+	}{
+		{"legacy key reads its file", http.MethodGet, "legacy-key", "legacy-key", http.StatusOK},
+		{"legacy key cannot read another key's file", http.MethodGet, "legacy-key", "project-key", http.StatusNotFound},
+		{"legacy key cannot delete another key's file", http.MethodDelete, "legacy-key", "project-key", http.StatusNotFound},
+		{"key cannot read the legacy key's file", http.MethodGet, "project-key", "legacy-key", http.StatusNotFound},
+		{"key reads its file", http.MethodGet, "project-key", "project-key", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := filesRequest(t, r, tt.method, "/v1/files/"+uploads[tt.file].ID, tt.key, "")
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+
+	lists := []struct {
+		path string
+		key  string
+		want int
+	}{
+		{"/v1/files", "legacy-key", 1},
+		{"/v1/files", "project-key", 1},
+		{"/admin/files", "admin-key", 2},
+		{"/admin/files", "legacy-key", 0},
+	}
+	for _, tt := range lists {
+		w := filesRequest(t, r, http.MethodGet, tt.path, tt.key, "")
+		var list model.FileList
+		json.Unmarshal(w.Body.Bytes(), &list)
+		if len(list.Data) != tt.want {
+			t.Errorf("GET %s with %s = %d files, want %d: %s", tt.path, tt.key, len(list.Data), tt.want, w.Body.String())
+		}
+	}
+}
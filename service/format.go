@@ -0,0 +1,33 @@
+package service
+
+import (
+	"encoding/json"
+	"pplx2api/config"
+	"pplx2api/model"
+	"pplx2api/utils"
+	"strings"
+)
+
+// responseFormatPrompt asks the model to answer with a JSON document matching the response format
+func responseFormatPrompt(cfg *config.Config, format *model.ResponseFormat) string {
+	var prompt strings.Builder
+	prompt.WriteString(utils.GetRolePrefix(cfg, "system"))
+	prompt.WriteString("Respond only with a valid JSON ")
+	if format.Type == "json_object" {
+		prompt.WriteString("object")
+	} else {
+		prompt.WriteString("document")
+	}
+	prompt.WriteString(". Do not wrap it in a markdown code block and do not write any text, explanation or citation before or after it.")
+	if schema := format.JSONSchema; schema != nil {
+		if schema.Description != "" {
+			prompt.WriteString(" The JSON describes " + schema.Description + ".")
+		}
+		if schema.Schema != nil {
+			data, _ := json.Marshal(schema.Schema)
+			prompt.WriteString(" It must conform to this JSON schema:\n" + string(data))
+		}
+	}
+	prompt.WriteString("\n\n")
+	return prompt.String()
+}
@@ -1,26 +1,40 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"pplx2api/config"
 	"pplx2api/core"
 	"pplx2api/logger"
+	"pplx2api/metrics"
+	"pplx2api/middleware"
+	"pplx2api/model"
+	"pplx2api/tokenizer"
 	"pplx2api/utils"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ChatCompletionRequest struct {
-	Model    string                   `json:"model"`
-	Messages []map[string]interface{} `json:"messages"`
-	Stream   bool                     `json:"stream"`
-	Tools    []map[string]interface{} `json:"tools,omitempty"`
+	Model          string                   `json:"model"`
+	Messages       []map[string]interface{} `json:"messages"`
+	Stream         bool                     `json:"stream"`
+	Tools          []map[string]interface{} `json:"tools,omitempty"`
+	ToolChoice     interface{}              `json:"tool_choice,omitempty"`
+	ResponseFormat *model.ResponseFormat    `json:"response_format,omitempty"`
+	// Stop is a stop sequence or a list of stop sequences
+	Stop                interface{}    `json:"stop,omitempty"`
+	MaxTokens           int            `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int            `json:"max_completion_tokens,omitempty"`
+	ReasoningMode       string         `json:"reasoning_mode,omitempty"`
+	StreamOptions       *StreamOptions `json:"stream_options,omitempty"`
 }
 
-type ErrorResponse struct {
-	Error string `json:"error"`
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // HealthCheckHandler handles the health check endpoint
@@ -30,52 +44,187 @@ func HealthCheckHandler(c *gin.Context) {
 	})
 }
 
-// ChatCompletionsHandler handles the chat completions endpoint
+// configContextKey caches the config snapshot of a request in the gin context
+const configContextKey = "config"
+
+// requestConfig returns the config snapshot of the request, it is taken once so a reload
+// never changes the settings halfway through a request
+func requestConfig(c *gin.Context) *config.Config {
+	if cfg, ok := c.Get(configContextKey); ok {
+		return cfg.(*config.Config)
+	}
+	cfg := config.Current()
+	c.Set(configContextKey, cfg)
+	return cfg
+}
+
+// Handler serves the OpenAI and Anthropic compatible endpoints on top of an Upstream
+type Handler struct {
+	newUpstream core.UpstreamFactory
+}
+
+// NewHandler creates a handler that builds one Upstream per session attempt
+func NewHandler(newUpstream core.UpstreamFactory) *Handler {
+	return &Handler{
+		newUpstream: newUpstream,
+	}
+}
+
+var defaultHandler = NewHandler(core.NewUpstream)
+
+// ChatCompletionsHandler handles the chat completions endpoint with the real Perplexity client
 func ChatCompletionsHandler(c *gin.Context) {
+	defaultHandler.ChatCompletions(c)
+}
+
+// ChatCompletions handles the chat completions endpoint
+func (h *Handler) ChatCompletions(c *gin.Context) {
 
 	// Parse request body
 	var req ChatCompletionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: fmt.Sprintf("Invalid request: %v", err),
-		})
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid request: %v", err))
 		return
 	}
 	// logger.Info(fmt.Sprintf("Received request: %v", req))
 	// Validate request
 	if len(req.Messages) == 0 {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: "No messages provided",
-		})
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "missing_messages", "No messages provided")
+		return
+	}
+
+	cfg := requestConfig(c)
+	defaultMode := model.ReasoningMode(cfg.ReasoningMode)
+	reasoningMode, err := model.ParseReasoningMode(req.ReasoningMode, defaultMode)
+	if err != nil {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "invalid_reasoning_mode", err.Error())
+		return
+	}
+
+	tools, err := parseTools(req.Tools)
+	if err != nil {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "invalid_tools", err.Error())
+		return
+	}
+	choice, err := parseToolChoice(req.ToolChoice, tools)
+	if err != nil {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "invalid_tool_choice", err.Error())
 		return
 	}
 
+	if req.ResponseFormat != nil {
+		if err := req.ResponseFormat.Check(); err != nil {
+			model.AbortWithOpenAIError(c, http.StatusBadRequest, "invalid_response_format", err.Error())
+			return
+		}
+		// <think> 标签会破坏 JSON 内容，JSON 模式下改为不输出思考过程
+		if req.ResponseFormat.JSON() && reasoningMode == model.ReasoningThink {
+			reasoningMode = model.ReasoningHidden
+		}
+	}
+
+	limits, err := parseLimits(req)
+	if err != nil {
+		model.AbortWithOpenAIError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	pplxModel, openSearch := parseModel(cfg, req.Model)
+	prompt, img_data_list, files := buildPrompt(cfg, req.Messages)
+	var names []string
+	if len(tools) > 0 && choice.Mode != "none" {
+		prompt = toolsPrompt(cfg, tools, choice) + prompt
+		names = toolNames(tools)
+	}
+	if req.ResponseFormat.JSON() {
+		prompt += responseFormatPrompt(cfg, req.ResponseFormat)
+	}
+	opts := model.OpenAIOptions{
+		Stream:         req.Stream,
+		Model:          publicModel(req.Model),
+		ReasoningMode:  reasoningMode,
+		PromptTokens:   tokenizer.Count(prompt),
+		IncludeUsage:   req.StreamOptions != nil && req.StreamOptions.IncludeUsage,
+		Tools:          names,
+		ResponseFormat: req.ResponseFormat,
+	}
+	h.complete(c, pplxModel, openSearch, prompt, img_data_list, files, func() model.Renderer {
+		return model.NewLimitRenderer(model.NewOpenAIRenderer(c, opts), limits)
+	})
+}
+
+// parseLimits reads the stop sequences and the token limit of the request
+func parseLimits(req ChatCompletionRequest) (model.Limits, error) {
+	limits := model.Limits{MaxTokens: req.MaxTokens}
+	if req.MaxCompletionTokens > 0 {
+		limits.MaxTokens = req.MaxCompletionTokens
+	}
+	if limits.MaxTokens < 0 {
+		return limits, fmt.Errorf("max_tokens must not be negative")
+	}
+	switch stop := req.Stop.(type) {
+	case nil:
+	case string:
+		limits.Stop = []string{stop}
+	case []interface{}:
+		for _, item := range stop {
+			sequence, ok := item.(string)
+			if !ok {
+				return limits, fmt.Errorf("stop must be a string or an array of strings")
+			}
+			limits.Stop = append(limits.Stop, sequence)
+		}
+	default:
+		return limits, fmt.Errorf("stop must be a string or an array of strings")
+	}
+	return limits, nil
+}
+
+// publicModel returns the model id echoed back to the client
+func publicModel(requested string) string {
 	// Get model or use default
-	model := req.Model
-	if model == "" {
-		model = "claude-3.7-sonnet"
+	if requested == "" {
+		return config.DefaultModel
 	}
+	return requested
+}
+
+// parseModel maps the public model name to the Perplexity model preference with the model map of cfg
+func parseModel(cfg *config.Config, requested string) (string, bool) {
+	model := publicModel(requested)
 	openSearch := false
 	if strings.HasSuffix(model, "-search") {
 		openSearch = true
 		model = strings.TrimSuffix(model, "-search")
 	}
-	model = config.ModelMapGet(model, model) // 获取模型名称
+	if pplxModel, ok := cfg.ModelMap[model]; ok { // 获取模型名称
+		model = pplxModel
+	}
+	return model, openSearch
+}
+
+// buildPrompt formats OpenAI style messages into a single prompt and collects the image data and files
+func buildPrompt(cfg *config.Config, messages []map[string]interface{}) (string, []string, []fileInput) {
 	var prompt strings.Builder
 	img_data_list := []string{}
+	files := []fileInput{}
 	// Format messages into a single prompt
-	for _, msg := range req.Messages {
+	for _, msg := range messages {
 		role, roleOk := msg["role"].(string)
 		if !roleOk {
 			continue // 忽略无效格式
 		}
 
 		content, exists := msg["content"]
-		if !exists {
+		toolCalls := messageToolCalls(msg)
+		if !exists && len(toolCalls) == 0 {
 			continue
 		}
 
-		prompt.WriteString(utils.GetRolePrefix(role)) // 获取角色前缀
+		prompt.WriteString(utils.GetRolePrefix(cfg, role)) // 获取角色前缀
+		if id, ok := msg["tool_call_id"].(string); ok && role == "tool" {
+			prompt.WriteString(fmt.Sprintf("(result of %s) ", id))
+		}
 		switch v := content.(type) {
 		case string: // 如果 content 直接是 string
 			prompt.WriteString(v + "\n\n")
@@ -93,78 +242,202 @@ func ChatCompletionsHandler(c *gin.Context) {
 									if len(url) > 50 {
 										logger.Info(fmt.Sprintf("Image URL: %s ……", url[:50]))
 									}
-									if strings.HasPrefix(url, "data:image/") {
-										// 保留 base64 编码的图片数据
-										url = strings.Split(url, ",")[1]
-									}
+									// 保留完整的 data URL，上传时根据其中的类型和文件内容确定图片格式
 									img_data_list = append(img_data_list, url) // 收集图片数据
 								}
 							}
+						} else if itemType == "file" {
+							files = append(files, parseFilePart(itemMap)) // 收集文件
 						}
 					}
 				}
 			}
 		}
+		// 助手的工具调用以与模型输出相同的格式写回提示词
+		if len(toolCalls) > 0 {
+			prompt.WriteString(model.FormatToolCalls(toolCalls) + "\n\n")
+		}
+	}
+	return prompt.String(), img_data_list, files
+}
+
+// complete sends the prompt upstream and renders the answer, switching sessions on failure
+func (h *Handler) complete(c *gin.Context, pplxModel string, openSearch bool, rootPrompt string, img_data_list []string, files []fileInput, newRenderer func() model.Renderer) {
+	requestID := c.GetString(logger.RequestIDKey)
+	log := logger.WithRequestID(requestID)
+	cfg := requestConfig(c)
+	log.Info("Prompt: %s, images: %d, files: %d", logger.Prompt(rootPrompt), len(img_data_list), len(files))
+	img_data_list, err := fetchRemoteImages(cfg, img_data_list, log)
+	if err != nil {
+		log.Warn(err.Error())
+		newRenderer().Error(http.StatusBadRequest, "invalid_image_url", err.Error())
+		return
+	}
+	fileImages, documents, err := resolveFiles(files, fileOwner(c))
+	if err != nil {
+		log.Warn(err.Error())
+		newRenderer().Error(http.StatusBadRequest, "invalid_file", err.Error())
+		return
 	}
-	fmt.Println(prompt.String())                             // 输出最终构造的内容
-	fmt.Println("img_data_list_length:", len(img_data_list)) // 输出图片数据列表长度
-	var rootPrompt strings.Builder
-	rootPrompt.WriteString(prompt.String())
+	img_data_list = append(img_data_list, fileImages...)
 	// 切号重试机制
-	var pplxClient *core.Client
-	index := config.Sr.NextIndex()
-	for i := 0; i < config.ConfigInstance.RetryCount; i++ {
-		if i > 0 {
-			prompt.Reset()
-			prompt.WriteString(rootPrompt.String())
-		}
-		index = (index + 1) % len(config.ConfigInstance.Sessions)
-		session, err := config.ConfigInstance.GetSessionForModel(index)
-		logger.Info(fmt.Sprintf("Using session for model %s: %s", model, session.SessionKey))
-		if err != nil {
-			logger.Error(fmt.Sprintf("Failed to get session for model %s: %v", model, err))
-			logger.Info("Retrying another session")
-			continue
+	var pplxClient core.Upstream
+	var failures attemptFailures
+	attempts := 0
+	defer func() {
+		metrics.Retries.Observe(float64(max(attempts-1, 0)))
+	}()
+	for i := 0; i < cfg.SessionCount(); i++ {
+		prompt := rootPrompt
+		session, index, ok := cfg.NextSession()
+		if !ok {
+			log.Error("No available session, all sessions are cooling down or invalid")
+			break
 		}
-		// Initialize the Claude client
-		pplxClient = core.NewClient(session.SessionKey, config.ConfigInstance.Proxy, model, openSearch)
+		attempts++
+		metrics.SessionPicks.WithLabelValues(strconv.Itoa(index)).Inc()
+		log.Info(fmt.Sprintf("Using session for model %s: %s", pplxModel, logger.Secret(session.SessionKey)))
+		// Initialize the upstream client
+		pplxClient = h.newUpstream(cfg, session.SessionKey, pplxModel, openSearch)
+		pplxClient.SetRequestID(requestID)
 		if len(img_data_list) > 0 {
 			err := pplxClient.UploadImage(img_data_list)
+			var inputErr *core.InputError
+			if errors.As(err, &inputErr) {
+				newRenderer().Error(http.StatusBadRequest, "invalid_image", err.Error())
+				return
+			}
+			if err != nil {
+				statusCode := core.StatusCode(err)
+				config.Health.ReportFailure(session.SessionKey, statusCode, err)
+				failures.add(statusCode, err)
+				log.Error(fmt.Sprintf("Failed to upload file: %v", err))
+				log.Info("Retrying another session")
+
+				continue
+			}
+		}
+		if len(documents) > 0 {
+			err := pplxClient.UploadFiles(documents)
 			if err != nil {
-				logger.Error(fmt.Sprintf("Failed to upload file: %v", err))
-				logger.Info("Retrying another session")
+				statusCode := core.StatusCode(err)
+				config.Health.ReportFailure(session.SessionKey, statusCode, err)
+				failures.add(statusCode, err)
+				log.Error(fmt.Sprintf("Failed to upload document: %v", err))
+				log.Info("Retrying another session")
 
 				continue
 			}
 		}
-		if prompt.Len() > config.ConfigInstance.MaxChatHistoryLength {
-			err := pplxClient.UploadText(prompt.String())
+		if len(prompt) > cfg.MaxChatHistoryLength {
+			err := pplxClient.UploadText(prompt)
 			if err != nil {
-				logger.Error(fmt.Sprintf("Failed to upload text: %v", err))
-				logger.Info("Retrying another session")
+				statusCode := core.StatusCode(err)
+				config.Health.ReportFailure(session.SessionKey, statusCode, err)
+				failures.add(statusCode, err)
+				log.Error(fmt.Sprintf("Failed to upload text: %v", err))
+				log.Info("Retrying another session")
 
 				continue
 			}
-			prompt.Reset()
-			prompt.WriteString(config.ConfigInstance.PromptForFile)
+			prompt = cfg.PromptForFile
 		}
-		if _, err := pplxClient.SendMessage(prompt.String(), req.Stream, config.ConfigInstance.IsIncognito, c); err != nil {
-			logger.Error(fmt.Sprintf("Failed to send message: %v", err))
-			logger.Info("Retrying another session")
+		renderer := newRenderer()
+		if statusCode, err := pplxClient.SendMessage(prompt, cfg.IsIncognito, renderer, c); err != nil {
+			var formatErr *model.OutputFormatError
+			if errors.As(err, &formatErr) && !renderer.Started() {
+				// session 正常，只是回答不符合 response_format，换一个 session 重新生成
+				config.Health.ReportSuccess(session.SessionKey)
+				failures.addInvalidOutput(err)
+				log.Warn(fmt.Sprintf("Invalid answer: %v", err))
+				log.Info("Retrying another session")
+				continue
+			}
+			config.Health.ReportFailure(session.SessionKey, statusCode, err)
+			log.Error(fmt.Sprintf("Failed to send message: %v", err))
+			if renderer.Started() {
+				// 已经开始向客户端输出，不能再切号重试
+				renderer.Error(http.StatusBadGateway, "upstream_error", fmt.Sprintf("Upstream stream interrupted: %v", err))
+				return
+			}
+			failures.add(statusCode, err)
+			log.Info("Retrying another session")
 
 			continue // Retry on error
 		}
+		config.Health.ReportSuccess(session.SessionKey)
 
 		return
 
 	}
-	logger.Error("Failed for all retries")
-	c.JSON(http.StatusInternalServerError, ErrorResponse{
-		Error: "Failed to process request after multiple attempts"})
+	log.Error("Failed for all retries")
+	status, code, message := failures.response(cfg)
+	newRenderer().Error(status, code, message)
+}
+
+// attemptFailures collects the upstream outcome of every session attempt
+type attemptFailures struct {
+	total         int
+	rateLimited   int
+	authFailed    int
+	invalidOutput int
+	last          error
+}
+
+func (f *attemptFailures) add(statusCode int, err error) {
+	f.total++
+	f.last = err
+	switch statusCode {
+	case http.StatusTooManyRequests:
+		f.rateLimited++
+	case http.StatusUnauthorized, http.StatusForbidden:
+		f.authFailed++
+	}
+}
+
+// addInvalidOutput records an answer that did not match the requested response format
+func (f *attemptFailures) addInvalidOutput(err error) {
+	f.total++
+	f.invalidOutput++
+	f.last = err
+}
+
+// response maps the collected failures to the status code returned to the client,
+// cfg is the config snapshot the attempts were made with
+func (f *attemptFailures) response(cfg *config.Config) (int, string, string) {
+	if f.total == 0 {
+		// 没有可用的 session
+		for _, session := range cfg.SessionsSnapshot() {
+			if config.Health.Get(session.SessionKey).State == config.SessionRateLimited {
+				return http.StatusTooManyRequests, "rate_limit_exceeded", "All sessions are rate limited, please retry later"
+			}
+		}
+		return http.StatusServiceUnavailable, "no_available_session", "No available session"
+	}
+	switch {
+	case f.authFailed == f.total:
+		return http.StatusUnauthorized, "upstream_unauthorized", "All sessions were rejected by Perplexity, check the session tokens"
+	case f.invalidOutput == f.total:
+		return http.StatusBadGateway, "invalid_json_output", fmt.Sprintf("The model did not return valid JSON after %d attempts: %v", f.total, f.last)
+	case f.rateLimited > 0 && f.rateLimited+f.authFailed == f.total:
+		return http.StatusTooManyRequests, "rate_limit_exceeded", "All sessions are rate limited, please retry later"
+	default:
+		return http.StatusBadGateway, "upstream_error", fmt.Sprintf("Failed to process request after %d attempts: %v", f.total, f.last)
+	}
 }
 
 func MoudlesHandler(c *gin.Context) {
+	models := config.GetResponseModels()
+	// 多密钥模式下只返回该密钥允许使用的模型
+	if info, ok := c.Get(middleware.APIKeyContextKey); ok {
+		models = []map[string]string{}
+		for _, m := range config.GetResponseModels() {
+			if info.(config.APIKeyInfo).AllowsModel(m["id"]) {
+				models = append(models, m)
+			}
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"data": config.ResponseModles,
+		"data": models,
 	})
 }
@@ -0,0 +1,325 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"pplx2api/config"
+	"pplx2api/fakepplx"
+	"pplx2api/middleware"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startFake points the config at a fake Perplexity with the given sessions and returns a router serving the API
+func startFake(t *testing.T, sessions ...string) (*fakepplx.Server, *gin.Engine) {
+	t.Helper()
+	previous := config.Current()
+	previousSessions := previous.SessionsSnapshot()
+	fake, srv := fakepplx.Start()
+	t.Cleanup(func() {
+		srv.Close()
+		config.Store(previous)
+		previous.SetSessions(previousSessions)
+		for _, key := range sessions {
+			config.Health.Remove(key)
+		}
+	})
+	fakepplx.Apply(srv.URL)
+	cfg := config.Current().Clone()
+	cfg.APIKey = "test-key"
+	cfg.AttachmentCacheSize = 0
+	config.Store(cfg)
+	var infos []config.SessionInfo
+	for _, key := range sessions {
+		infos = append(infos, config.SessionInfo{SessionKey: key})
+	}
+	cfg.SetSessions(infos)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/chat/completions", middleware.AuthMiddleware(), ChatCompletionsHandler)
+	r.POST("/v1/messages", middleware.AuthMiddleware(), MessagesHandler)
+	return fake, r
+}
+
+func postChat(r *gin.Engine, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-key")
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func pngDataURL(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestChatCompletionsAgainstFakePerplexity(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions []string
+		// failing sessions answer every request with the status
+		failing    map[string]int
+		body       func(t *testing.T) string
+		wantStatus int
+		// wantBody are fragments of the response body
+		wantBody []string
+		// wantAskSessions are the sessions of the ask requests, in order
+		wantAskSessions []string
+		wantUploads     int
+	}{
+		{
+			name:     "streaming ask",
+			sessions: []string{"stream-session"},
+			body: func(t *testing.T) string {
+				return `{"model":"claude-4.0-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+			},
+			wantStatus:      http.StatusOK,
+			wantBody:        []string{`"content":"Hello"`, `"content":" Perplexity"`, `"finish_reason":"stop"`, "data: [DONE]"},
+			wantAskSessions: []string{"stream-session"},
+		},
+		{
+			name:     "image upload",
+			sessions: []string{"image-session"},
+			body: func(t *testing.T) string {
+				return `{"model":"claude-4.0-sonnet","messages":[{"role":"user","content":[` +
+					`{"type":"text","text":"what is this"},{"type":"image_url","image_url":{"url":"` + pngDataURL(t) + `"}}]}]}`
+			},
+			wantStatus:      http.StatusOK,
+			wantBody:        []string{`"content":"Hello from fake Perplexity"`},
+			wantAskSessions: []string{"image-session"},
+			wantUploads:     1,
+		},
+		{
+			name:     "rotation after 401",
+			sessions: []string{"rejected-session", "working-session"},
+			failing:  map[string]int{"rejected-session": http.StatusUnauthorized},
+			body: func(t *testing.T) string {
+				return `{"model":"claude-4.0-sonnet","messages":[{"role":"user","content":"hi"}]}`
+			},
+			wantStatus:      http.StatusOK,
+			wantBody:        []string{`"content":"Hello from fake Perplexity"`},
+			wantAskSessions: []string{"working-session"},
+		},
+		{
+			name:     "every session rejected",
+			sessions: []string{"rejected-a", "rejected-b"},
+			failing:  map[string]int{"rejected-a": http.StatusUnauthorized, "rejected-b": http.StatusUnauthorized},
+			body: func(t *testing.T) string {
+				return `{"model":"claude-4.0-sonnet","messages":[{"role":"user","content":"hi"}]}`
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   []string{"upstream_unauthorized"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, r := startFake(t, tt.sessions...)
+			for key, status := range tt.failing {
+				fake.SetSessionStatus(key, status)
+			}
+			w := postChat(r, tt.body(t))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			for _, fragment := range tt.wantBody {
+				if !strings.Contains(w.Body.String(), fragment) {
+					t.Errorf("body does not contain %s:\n%s", fragment, w.Body.String())
+				}
+			}
+			var askSessions []string
+			for _, ask := range fake.Asks() {
+				askSessions = append(askSessions, ask.SessionToken)
+			}
+			if strings.Join(askSessions, ",") != strings.Join(tt.wantAskSessions, ",") {
+				t.Errorf("ask sessions = %v, want %v", askSessions, tt.wantAskSessions)
+			}
+			if got := len(fake.Uploads()); got != tt.wantUploads {
+				t.Errorf("uploads = %d, want %d", got, tt.wantUploads)
+			}
+			if tt.wantUploads > 0 {
+				asks := fake.Asks()
+				if len(asks) == 0 || len(asks[0].Params.Attachments) != tt.wantUploads {
+					t.Errorf("ask attachments = %v, want %d uploaded images", asks, tt.wantUploads)
+				}
+			}
+			for key := range tt.failing {
+				if health := config.Health.Get(key); health.ConsecutiveFailures == 0 {
+					t.Errorf("failure of %s was not reported: %+v", key, health)
+				}
+			}
+		})
+	}
+}
+
+func TestChatCompletionsStreamChunks(t *testing.T) {
+	tests := []struct {
+		name             string
+		displayModel     string
+		wantDisplayModel string
+	}{
+		{"answered by the requested model", "", ""},
+		{"answered by another model", "gpt41", "gpt-4.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, r := startFake(t, "chunk-session")
+			fake.SetReply(fakepplx.Reply{Chunks: []string{"one", " two"}, DisplayModel: tt.displayModel})
+			w := postChat(r, `{"model":"claude-4.0-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+				t.Fatalf("content type = %q, want text/event-stream", ct)
+			}
+			var text strings.Builder
+			displayModel := ""
+			for _, line := range strings.Split(w.Body.String(), "\n") {
+				data, ok := strings.CutPrefix(line, "data: ")
+				if !ok || data == "[DONE]" {
+					continue
+				}
+				var chunk struct {
+					Model        string `json:"model"`
+					DisplayModel string `json:"display_model"`
+					Choices      []struct {
+						Delta struct {
+							Content string `json:"content"`
+						} `json:"delta"`
+						FinishReason *string `json:"finish_reason"`
+					} `json:"choices"`
+				}
+				if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+					t.Fatalf("invalid chunk %s: %v", data, err)
+				}
+				// 流中的 model 始终是请求的模型，实际回答的模型只在最后的分块中返回
+				if chunk.Model != "claude-4.0-sonnet" {
+					t.Errorf("chunk model = %q, want claude-4.0-sonnet", chunk.Model)
+				}
+				if chunk.DisplayModel != "" && len(chunk.Choices) > 0 && chunk.Choices[0].FinishReason == nil {
+					t.Errorf("display_model %q sent before the final chunk: %s", chunk.DisplayModel, data)
+				}
+				if chunk.DisplayModel != "" {
+					displayModel = chunk.DisplayModel
+				}
+				for _, choice := range chunk.Choices {
+					text.WriteString(choice.Delta.Content)
+				}
+			}
+			if !strings.HasPrefix(text.String(), "one two") {
+				t.Errorf("streamed text = %q, want it to start with %q", text.String(), "one two")
+			}
+			if displayModel != tt.wantDisplayModel {
+				t.Errorf("display_model = %q, want %q", displayModel, tt.wantDisplayModel)
+			}
+		})
+	}
+}
+
+func TestMessagesModel(t *testing.T) {
+	tests := []struct {
+		name             string
+		stream           bool
+		displayModel     string
+		wantDisplayModel string
+	}{
+		{"stream answered by the requested model", true, "", ""},
+		{"stream answered by another model", true, "gpt41", "gpt-4.1"},
+		{"answered by the requested model", false, "", ""},
+		{"answered by another model", false, "gpt41", "gpt-4.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, r := startFake(t, "messages-session")
+			fake.SetReply(fakepplx.Reply{Chunks: []string{"hi"}, DisplayModel: tt.displayModel})
+			cfg := config.Current().Clone()
+			cfg.IgnoreModelMonitoring = true
+			config.Store(cfg)
+			body := fmt.Sprintf(`{"model":"claude-4.0-sonnet","stream":%t,"max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`, tt.stream)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer test-key")
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+
+			var message struct {
+				Model        string `json:"model"`
+				DisplayModel string `json:"display_model"`
+			}
+			if !tt.stream {
+				if err := json.Unmarshal(w.Body.Bytes(), &message); err != nil {
+					t.Fatal(err)
+				}
+			}
+			event := ""
+			for _, line := range strings.Split(w.Body.String(), "\n") {
+				if name, ok := strings.CutPrefix(line, "event: "); ok {
+					event = name
+					continue
+				}
+				data, ok := strings.CutPrefix(line, "data: ")
+				if !ok {
+					continue
+				}
+				var payload struct {
+					Message struct {
+						Model string `json:"model"`
+					} `json:"message"`
+					DisplayModel string `json:"display_model"`
+				}
+				if err := json.Unmarshal([]byte(data), &payload); err != nil {
+					t.Fatalf("invalid %s event %s: %v", event, data, err)
+				}
+				switch event {
+				case "message_start":
+					message.Model = payload.Message.Model
+				case "message_delta":
+					message.DisplayModel = payload.DisplayModel
+				}
+			}
+			// model 始终是请求的模型，实际回答的模型通过 display_model 返回
+			if message.Model != "claude-4.0-sonnet" {
+				t.Errorf("message model = %q, want claude-4.0-sonnet", message.Model)
+			}
+			if message.DisplayModel != tt.wantDisplayModel {
+				t.Errorf("display_model = %q, want %q", message.DisplayModel, tt.wantDisplayModel)
+			}
+		})
+	}
+}
+
+func TestParseModelUsesSnapshot(t *testing.T) {
+	cfg := config.Current().Clone()
+	cfg.ModelMap = map[string]string{"custom": "pplx-custom", "gpt-4o": "snapshot-gpt4o"}
+	tests := []struct {
+		requested  string
+		want       string
+		openSearch bool
+	}{
+		{"custom", "pplx-custom", false},
+		{"custom-search", "pplx-custom", true},
+		{"gpt-4o", "snapshot-gpt4o", false},
+		{"unknown", "unknown", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.requested, func(t *testing.T) {
+			got, openSearch := parseModel(cfg, tt.requested)
+			if got != tt.want || openSearch != tt.openSearch {
+				t.Errorf("parseModel(%q) = %q, %t, want %q, %t", tt.requested, got, openSearch, tt.want, tt.openSearch)
+			}
+		})
+	}
+}
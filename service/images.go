@@ -0,0 +1,28 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"pplx2api/config"
+	"pplx2api/logger"
+	"pplx2api/utils"
+)
+
+// fetchRemoteImages downloads the images given as http or https links and returns
+// them as data URLs, the way inline images are uploaded
+func fetchRemoteImages(cfg *config.Config, images []string, log *logger.Entry) ([]string, error) {
+	resolved := make([]string, 0, len(images))
+	for _, image := range images {
+		if !utils.IsRemoteURL(image) {
+			resolved = append(resolved, image)
+			continue
+		}
+		data, contentType, err := utils.FetchImage(cfg, image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch image %s: %v", logger.RedactURL(image), err)
+		}
+		log.Info(fmt.Sprintf("Fetched image %s (%s, %d bytes)", logger.RedactURL(image), contentType, len(data)))
+		resolved = append(resolved, "data:"+contentType+";base64,"+base64.StdEncoding.EncodeToString(data))
+	}
+	return resolved, nil
+}
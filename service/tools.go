@@ -0,0 +1,130 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"pplx2api/config"
+	"pplx2api/model"
+	"pplx2api/utils"
+	"strings"
+)
+
+// toolDefinition is a function the client offers to the model
+type toolDefinition struct {
+	Name        string
+	Description string
+	Parameters  interface{}
+}
+
+// toolChoice is the parsed tool_choice of the request
+type toolChoice struct {
+	// Mode is none, auto or required
+	Mode string
+	// Name is the function the model is forced to call
+	Name string
+}
+
+// parseTools reads the function definitions from the tools of the request
+func parseTools(tools []map[string]interface{}) ([]toolDefinition, error) {
+	definitions := make([]toolDefinition, 0, len(tools))
+	for i, tool := range tools {
+		if toolType, _ := tool["type"].(string); toolType != "function" {
+			return nil, fmt.Errorf("tools[%d]: unsupported tool type %q, only function is supported", i, toolType)
+		}
+		function, ok := tool["function"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("tools[%d]: missing function", i)
+		}
+		name, _ := function["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("tools[%d]: missing function name", i)
+		}
+		description, _ := function["description"].(string)
+		definitions = append(definitions, toolDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  function["parameters"],
+		})
+	}
+	return definitions, nil
+}
+
+// parseToolChoice reads "none", "auto", "required" or {"type":"function","function":{"name":...}}
+func parseToolChoice(choice interface{}, definitions []toolDefinition) (toolChoice, error) {
+	switch v := choice.(type) {
+	case nil:
+		return toolChoice{Mode: "auto"}, nil
+	case string:
+		switch v {
+		case "none", "auto", "required":
+			return toolChoice{Mode: v}, nil
+		}
+		return toolChoice{}, fmt.Errorf("invalid tool_choice %q, expected none, auto or required", v)
+	case map[string]interface{}:
+		function, _ := v["function"].(map[string]interface{})
+		name, _ := function["name"].(string)
+		for _, definition := range definitions {
+			if definition.Name == name {
+				return toolChoice{Mode: "required", Name: name}, nil
+			}
+		}
+		return toolChoice{}, fmt.Errorf("tool_choice function %q is not in tools", name)
+	}
+	return toolChoice{}, fmt.Errorf("invalid tool_choice")
+}
+
+// toolsPrompt describes the tools and how the model has to call them
+func toolsPrompt(cfg *config.Config, definitions []toolDefinition, choice toolChoice) string {
+	var prompt strings.Builder
+	prompt.WriteString(utils.GetRolePrefix(cfg, "system"))
+	prompt.WriteString("You can call the following tools. Each tool is listed with its name, description and the JSON schema of its arguments.\n\n")
+	for _, definition := range definitions {
+		prompt.WriteString("- " + definition.Name)
+		if definition.Description != "" {
+			prompt.WriteString(": " + definition.Description)
+		}
+		prompt.WriteString("\n")
+		if definition.Parameters != nil {
+			parameters, _ := json.Marshal(definition.Parameters)
+			prompt.WriteString("  Arguments schema: " + string(parameters) + "\n")
+		}
+	}
+	prompt.WriteString("\nTo call tools, reply with nothing but a fenced code block with the language " + model.ToolCallsFence + " that contains a JSON array of calls, for example:\n")
+	prompt.WriteString("```" + model.ToolCallsFence + "\n[{\"name\": \"tool_name\", \"arguments\": {\"argument\": \"value\"}}]\n```\n")
+	prompt.WriteString("The arguments must match the schema of the tool. The results of the calls will be sent back in Tool messages.\n")
+	switch {
+	case choice.Name != "":
+		prompt.WriteString(fmt.Sprintf("You must call the tool %s now.\n\n", choice.Name))
+	case choice.Mode == "required":
+		prompt.WriteString("You must call at least one tool now.\n\n")
+	default:
+		prompt.WriteString("If no tool is needed, answer normally without the code block.\n\n")
+	}
+	return prompt.String()
+}
+
+// toolNames returns the names of the functions the answer may call
+func toolNames(definitions []toolDefinition) []string {
+	names := make([]string, 0, len(definitions))
+	for _, definition := range definitions {
+		names = append(names, definition.Name)
+	}
+	return names
+}
+
+// messageToolCalls decodes the tool_calls of an assistant message
+func messageToolCalls(msg map[string]interface{}) []model.ToolCall {
+	raw, ok := msg["tool_calls"].([]interface{})
+	if !ok || len(raw) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var calls []model.ToolCall
+	if err := json.Unmarshal(data, &calls); err != nil {
+		return nil
+	}
+	return calls
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestParseTools(t *testing.T) {
+	tests := []struct {
+		name    string
+		tools   []map[string]interface{}
+		want    []string
+		wantErr bool
+	}{
+		{"no tools", nil, []string{}, false},
+		{
+			name: "functions",
+			tools: []map[string]interface{}{
+				{"type": "function", "function": map[string]interface{}{"name": "search", "parameters": map[string]interface{}{"type": "object"}}},
+				{"type": "function", "function": map[string]interface{}{"name": "get_weather", "description": "Weather"}},
+			},
+			want: []string{"search", "get_weather"},
+		},
+		{"unsupported type", []map[string]interface{}{{"type": "retrieval"}}, nil, true},
+		{"missing function", []map[string]interface{}{{"type": "function"}}, nil, true},
+		{"missing name", []map[string]interface{}{{"type": "function", "function": map[string]interface{}{}}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			definitions, err := parseTools(tt.tools)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTools() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			names := toolNames(definitions)
+			if len(names) != len(tt.want) {
+				t.Fatalf("names = %v, want %v", names, tt.want)
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Errorf("names = %v, want %v", names, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseToolChoice(t *testing.T) {
+	definitions := []toolDefinition{{Name: "search"}}
+	tests := []struct {
+		name    string
+		choice  interface{}
+		want    toolChoice
+		wantErr bool
+	}{
+		{"default", nil, toolChoice{Mode: "auto"}, false},
+		{"none", "none", toolChoice{Mode: "none"}, false},
+		{"required", "required", toolChoice{Mode: "required"}, false},
+		{"invalid mode", "always", toolChoice{}, true},
+		{"named function", map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "search"}}, toolChoice{Mode: "required", Name: "search"}, false},
+		{"unknown function", map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "other"}}, toolChoice{}, true},
+		{"invalid type", 1.0, toolChoice{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseToolChoice(tt.choice, definitions)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseToolChoice() error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseToolChoice() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageToolCalls(t *testing.T) {
+	msg := map[string]interface{}{
+		"role": "assistant",
+		"tool_calls": []interface{}{
+			map[string]interface{}{"id": "call_1", "type": "function", "function": map[string]interface{}{"name": "search", "arguments": `{"q":"go"}`}},
+		},
+	}
+	calls := messageToolCalls(msg)
+	if len(calls) != 1 || calls[0].ID != "call_1" || calls[0].Function.Name != "search" || calls[0].Function.Arguments != `{"q":"go"}` {
+		t.Errorf("messageToolCalls() = %+v", calls)
+	}
+	if calls := messageToolCalls(map[string]interface{}{"role": "assistant"}); calls != nil {
+		t.Errorf("messageToolCalls() without calls = %+v", calls)
+	}
+}
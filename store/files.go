@@ -0,0 +1,211 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pplx2api/config"
+	"pplx2api/model"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrFileNotFound is returned for unknown file ids and files of another API key
+var ErrFileNotFound = errors.New("file not found")
+
+// AllOwners is the owner of the admin API, which sees the files of every API key
+const AllOwners = "*"
+
+// fileIDPattern guards the file paths built from ids
+var fileIDPattern = regexp.MustCompile(`^file-[0-9a-f]{32}$`)
+
+// FileStore keeps uploaded files on local disk, each file <id> has its metadata in <id>.json
+type FileStore struct {
+	mu sync.RWMutex
+}
+
+// fileMeta is the metadata file of a stored file
+type fileMeta struct {
+	model.FileObject
+	ContentType string `json:"content_type,omitempty"`
+	// Owner 上传文件的 API 密钥的哈希，其他密钥不可见
+	Owner string `json:"owner,omitempty"`
+}
+
+// Files 全局文件存储
+var Files = &FileStore{}
+
+// dir returns the storage directory, read from the config so reloads take effect
+func (s *FileStore) dir() string {
+	return config.Current().FilesDir
+}
+
+func (s *FileStore) dataPath(id string) string {
+	return filepath.Join(s.dir(), id)
+}
+
+func (s *FileStore) metaPath(id string) string {
+	return filepath.Join(s.dir(), id+".json")
+}
+
+// visible reports whether owner may access the file, only AllOwners sees every file
+func (m fileMeta) visible(owner string) bool {
+	return owner == AllOwners || m.Owner == owner
+}
+
+// Create stores a file and returns its file object
+func (s *FileStore) Create(owner string, filename string, contentType string, purpose string, data []byte) (model.FileObject, error) {
+	if owner == "" || owner == AllOwners {
+		return model.FileObject{}, fmt.Errorf("invalid file owner %q", owner)
+	}
+	meta := fileMeta{
+		FileObject: model.FileObject{
+			ID:        "file-" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+			Object:    "file",
+			Bytes:     len(data),
+			CreatedAt: time.Now().Unix(),
+			Filename:  filename,
+			Purpose:   purpose,
+		},
+		ContentType: contentType,
+		Owner:       owner,
+	}
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return model.FileObject{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir(), 0755); err != nil {
+		return model.FileObject{}, fmt.Errorf("failed to create files directory: %w", err)
+	}
+	if err := writeFile(s.dataPath(meta.ID), data); err != nil {
+		return model.FileObject{}, err
+	}
+	// 元数据最后写入，没有元数据的文件不会被列出
+	if err := writeFile(s.metaPath(meta.ID), metaData); err != nil {
+		os.Remove(s.dataPath(meta.ID))
+		return model.FileObject{}, err
+	}
+	return meta.FileObject, nil
+}
+
+// writeFile writes through a temporary file so readers never see a partial file
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// readMeta loads the metadata of a file visible to owner
+func (s *FileStore) readMeta(owner string, id string) (fileMeta, error) {
+	var meta fileMeta
+	if !fileIDPattern.MatchString(id) {
+		return meta, ErrFileNotFound
+	}
+	data, err := os.ReadFile(s.metaPath(id))
+	if os.IsNotExist(err) {
+		return meta, ErrFileNotFound
+	}
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("failed to parse metadata of %s: %w", id, err)
+	}
+	if !meta.visible(owner) {
+		return meta, ErrFileNotFound
+	}
+	return meta, nil
+}
+
+// List returns the files visible to owner, newest first, optionally filtered by purpose
+func (s *FileStore) List(owner string, purpose string) ([]model.FileObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries, err := os.ReadDir(s.dir())
+	if os.IsNotExist(err) {
+		return []model.FileObject{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := []model.FileObject{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !fileIDPattern.MatchString(id) {
+			continue
+		}
+		meta, err := s.readMeta(owner, id)
+		if errors.Is(err, ErrFileNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if purpose != "" && meta.Purpose != purpose {
+			continue
+		}
+		files = append(files, meta.FileObject)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].CreatedAt > files[j].CreatedAt
+	})
+	return files, nil
+}
+
+// Get returns the file object of a file
+func (s *FileStore) Get(owner string, id string) (model.FileObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	meta, err := s.readMeta(owner, id)
+	return meta.FileObject, err
+}
+
+// Content returns the file object, the content type given at upload and the content of a file
+func (s *FileStore) Content(owner string, id string) (model.FileObject, string, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	meta, err := s.readMeta(owner, id)
+	if err != nil {
+		return model.FileObject{}, "", nil, err
+	}
+	data, err := os.ReadFile(s.dataPath(id))
+	if os.IsNotExist(err) {
+		return model.FileObject{}, "", nil, ErrFileNotFound
+	}
+	if err != nil {
+		return model.FileObject{}, "", nil, err
+	}
+	return meta.FileObject, meta.ContentType, data, nil
+}
+
+// Delete removes a file and its metadata
+func (s *FileStore) Delete(owner string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.readMeta(owner, id); err != nil {
+		return err
+	}
+	if err := os.Remove(s.metaPath(id)); err != nil {
+		return err
+	}
+	if err := os.Remove(s.dataPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
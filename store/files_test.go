@@ -0,0 +1,150 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"pplx2api/config"
+	"testing"
+)
+
+// withFilesDir publishes a config storing files in a temporary directory
+func withFilesDir(t *testing.T) (*FileStore, string) {
+	t.Helper()
+	previous := config.Current()
+	t.Cleanup(func() { config.Store(previous) })
+	cfg := previous.Clone()
+	cfg.FilesDir = t.TempDir()
+	config.Store(cfg)
+	return &FileStore{}, cfg.FilesDir
+}
+
+func TestFileStoreOwnerIsolation(t *testing.T) {
+	s, _ := withFilesDir(t)
+	a, err := s.Create("owner-a", "a.txt", "text/plain", "assistants", []byte("from a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Create("owner-b", "b.txt", "text/plain", "user_data", []byte("from b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		owner string
+		id    string
+		// want is the content visible to the owner, empty when the file is hidden
+		want string
+	}{
+		{"owner reads its file", "owner-a", a.ID, "from a"},
+		{"other owner", "owner-b", a.ID, ""},
+		{"unknown owner", "owner-c", b.ID, ""},
+		{"admin sees every file", AllOwners, b.ID, "from b"},
+		{"empty owner", "", b.ID, ""},
+		{"unknown id", "owner-a", "file-00000000000000000000000000000000", ""},
+		{"path traversal", AllOwners, "../" + filepath.Base(a.ID), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, getErr := s.Get(tt.owner, tt.id)
+			_, _, data, contentErr := s.Content(tt.owner, tt.id)
+			if tt.want == "" {
+				if !errors.Is(getErr, ErrFileNotFound) || !errors.Is(contentErr, ErrFileNotFound) {
+					t.Errorf("Get() error = %v, Content() error = %v, want ErrFileNotFound", getErr, contentErr)
+				}
+				return
+			}
+			if getErr != nil || contentErr != nil || string(data) != tt.want {
+				t.Errorf("Content() = %q, %v, %v, want %q", data, getErr, contentErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileStoreList(t *testing.T) {
+	s, _ := withFilesDir(t)
+	for _, file := range []struct{ owner, name, purpose string }{
+		{"owner-a", "a1.txt", "assistants"},
+		{"owner-a", "a2.txt", "user_data"},
+		{"owner-b", "b1.txt", "assistants"},
+	} {
+		if _, err := s.Create(file.owner, file.name, "text/plain", file.purpose, []byte(file.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		owner   string
+		purpose string
+		want    int
+	}{
+		{"owner-a", "", 2},
+		{"owner-a", "assistants", 1},
+		{"owner-b", "", 1},
+		{"owner-b", "user_data", 0},
+		{"owner-c", "", 0},
+		{"", "", 0},
+		{AllOwners, "", 3},
+	}
+	for _, tt := range tests {
+		files, err := s.List(tt.owner, tt.purpose)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != tt.want {
+			t.Errorf("List(%q, %q) = %d files, want %d", tt.owner, tt.purpose, len(files), tt.want)
+		}
+		for _, file := range files {
+			if tt.purpose != "" && file.Purpose != tt.purpose {
+				t.Errorf("List(%q, %q) returned %s with purpose %s", tt.owner, tt.purpose, file.Filename, file.Purpose)
+			}
+		}
+	}
+}
+
+func TestFileStoreCreateRequiresOwner(t *testing.T) {
+	s, _ := withFilesDir(t)
+	for _, owner := range []string{"", AllOwners} {
+		if _, err := s.Create(owner, "a.txt", "text/plain", "assistants", []byte("data")); err == nil {
+			t.Errorf("Create(%q) succeeded, want an error", owner)
+		}
+	}
+}
+
+func TestFileStoreDelete(t *testing.T) {
+	s, dir := withFilesDir(t)
+	file, err := s.Create("owner-a", "a.txt", "text/plain", "assistants", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("owner-b", file.ID); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("Delete() by another owner error = %v, want ErrFileNotFound", err)
+	}
+	if _, err := s.Get("owner-a", file.ID); err != nil {
+		t.Fatalf("file is gone after a denied delete: %v", err)
+	}
+	if err := s.Delete("owner-a", file.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("owner-a", file.ID); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Get() after Delete error = %v, want ErrFileNotFound", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("files left after Delete: %v", entries)
+	}
+}
+
+func TestFileStoreListWithoutDirectory(t *testing.T) {
+	s, dir := withFilesDir(t)
+	cfg := config.Current().Clone()
+	cfg.FilesDir = filepath.Join(dir, "missing")
+	config.Store(cfg)
+	files, err := s.List("", "")
+	if err != nil || len(files) != 0 {
+		t.Errorf("List() = %v, %v, want no files", files, err)
+	}
+}
@@ -0,0 +1,45 @@
+package tokenizer
+
+import (
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// CL100K counts tokens with the cl100k_base BPE used by GPT-4 and GPT-3.5.
+// The ranks are embedded in the binary, so no download is needed.
+type CL100K struct {
+	encoding *tiktoken.Tiktoken
+}
+
+// NewCL100K loads the cl100k_base ranks
+func NewCL100K() (*CL100K, error) {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	encoding, err := tiktoken.GetEncoding(tiktoken.MODEL_CL100K_BASE)
+	if err != nil {
+		return nil, err
+	}
+	return &CL100K{encoding: encoding}, nil
+}
+
+// Count counts the tokens of text, special tokens such as <|endoftext|> are counted as plain text
+func (t *CL100K) Count(text string) int {
+	return len(t.encoding.EncodeOrdinary(text))
+}
+
+// Truncate returns the text of the first tokens tokens, without a rune cut in half
+func (t *CL100K) Truncate(text string, tokens int) string {
+	if tokens <= 0 {
+		return ""
+	}
+	ids := t.encoding.EncodeOrdinary(text)
+	if len(ids) <= tokens {
+		return text
+	}
+	prefix := t.encoding.Decode(ids[:tokens])
+	for len(prefix) > 0 && !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return prefix
+}
@@ -0,0 +1,162 @@
+// Package tokenizer estimates token counts for usage reporting.
+package tokenizer
+
+import (
+	"sort"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer counts the tokens of a text
+type Tokenizer interface {
+	Count(text string) int
+}
+
+var (
+	// current 为空时使用 cl100k_base 分词，加载失败时退回 Estimator
+	current   Tokenizer
+	currentMu sync.RWMutex
+)
+
+// defaultTokenizer loads the cl100k_base ranks on first use
+var defaultTokenizer = sync.OnceValue(func() Tokenizer {
+	t, err := NewCL100K()
+	if err != nil {
+		return Estimator{}
+	}
+	return t
+})
+
+// SetDefault replaces the tokenizer used by Count, nil restores cl100k_base
+func SetDefault(t Tokenizer) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = t
+}
+
+// Truncater is implemented by tokenizers that can cut a text at a token boundary
+type Truncater interface {
+	Truncate(text string, tokens int) string
+}
+
+func get() Tokenizer {
+	currentMu.RLock()
+	t := current
+	currentMu.RUnlock()
+	if t == nil {
+		t = defaultTokenizer()
+	}
+	return t
+}
+
+// Count counts the tokens of text with the default tokenizer
+func Count(text string) int {
+	return get().Count(text)
+}
+
+// Truncate returns the longest beginning of text that fits in tokens with the default tokenizer
+func Truncate(text string, tokens int) string {
+	t := get()
+	if truncater, ok := t.(Truncater); ok {
+		return truncater.Truncate(text, tokens)
+	}
+	// 没有分词边界时按字符二分查找，要求计数随长度单调增加
+	offsets := make([]int, 0, len(text)+1)
+	for i := range text {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+	n := sort.Search(len(offsets), func(i int) bool {
+		return t.Count(text[:offsets[i]]) > tokens
+	})
+	if n == 0 {
+		return ""
+	}
+	return text[:offsets[n-1]]
+}
+
+// Estimator is a rough heuristic for when the BPE ranks are not available.
+// It splits the text into words, digit groups, punctuation runs and newlines
+// and prices every piece with a fixed rule; it is not calibrated against
+// cl100k_base and can be off by a wide margin, e.g. for code or rare words.
+type Estimator struct{}
+
+func (Estimator) Count(text string) int {
+	tokens := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case isCJK(r):
+			// 中日韩字符基本每个字符一个 token
+			tokens++
+			i += size
+		case unicode.IsLetter(r):
+			n := 0
+			for i < len(text) {
+				r, size = utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsLetter(r) || isCJK(r) {
+					break
+				}
+				n++
+				i += size
+			}
+			tokens += wordTokens(n)
+		case unicode.IsDigit(r):
+			n := 0
+			for i < len(text) {
+				r, size = utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsDigit(r) {
+					break
+				}
+				n++
+				i += size
+			}
+			tokens += (n + 2) / 3
+		case r == '\n' || r == '\r':
+			for i < len(text) && (text[i] == '\n' || text[i] == '\r') {
+				i++
+			}
+			tokens++
+		case unicode.IsSpace(r):
+			// 单个空格会与后面的词合并
+			n := 0
+			for i < len(text) {
+				r, size = utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsSpace(r) || r == '\n' || r == '\r' {
+					break
+				}
+				n++
+				i += size
+			}
+			if n > 1 {
+				tokens++
+			}
+		default:
+			n := 0
+			for i < len(text) {
+				r, size = utf8.DecodeRuneInString(text[i:])
+				if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) {
+					break
+				}
+				n++
+				i += size
+			}
+			tokens += (n + 1) / 2
+		}
+	}
+	return tokens
+}
+
+// wordTokens prices a run of n letters: common words are a single token,
+// longer words are split into pieces of about four letters
+func wordTokens(n int) int {
+	if n <= 6 {
+		return 1
+	}
+	return 1 + (n-6+3)/4
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
@@ -0,0 +1,119 @@
+package tokenizer
+
+import (
+	"sync"
+	"testing"
+)
+
+// cl100kCounts are token counts of cl100k_base published with tiktoken
+var cl100kCounts = []struct {
+	text string
+	want int
+}{
+	{"hello world", 2},
+	{"tiktoken is great!", 6},
+	{"antidisestablishmentarianism", 6},
+	{"2 + 2 = 4", 7},
+	{"お誕生日おめでとう", 9},
+	{"", 0},
+}
+
+func TestCL100K(t *testing.T) {
+	encoding, err := NewCL100K()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range cl100kCounts {
+		if got := encoding.Count(tt.text); got != tt.want {
+			t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCountUsesCL100K(t *testing.T) {
+	for _, tt := range cl100kCounts {
+		if got := Count(tt.text); got != tt.want {
+			t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCountConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, tt := range cl100kCounts {
+				if got := Count(tt.text); got != tt.want {
+					t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestEstimator(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello world", 2},
+		{"hi   there", 3},
+		{"antidisestablishmentarianism", 7},
+		{"12345", 2},
+		{"2 + 2 = 4", 5},
+		{"!!!", 2},
+		{"a\n\nb", 3},
+		{"你好", 2},
+		{"お誕生日おめでとう", 9},
+	}
+	for _, tt := range tests {
+		if got := (Estimator{}).Count(tt.text); got != tt.want {
+			t.Errorf("Estimator.Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	t.Cleanup(func() { SetDefault(nil) })
+	SetDefault(Estimator{})
+	if got := Count("antidisestablishmentarianism"); got != 7 {
+		t.Errorf("Count with the estimator = %d, want 7", got)
+	}
+	SetDefault(nil)
+	if got := Count("antidisestablishmentarianism"); got != 6 {
+		t.Errorf("Count after restoring cl100k_base = %d, want 6", got)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name   string
+		t      Tokenizer
+		text   string
+		tokens int
+		want   string
+	}{
+		{"cl100k token boundary", nil, "one two three four", 3, "one two three"},
+		{"cl100k fits", nil, "one two", 5, "one two"},
+		{"cl100k no tokens", nil, "one two", 0, ""},
+		{"cl100k keeps whole runes", nil, "お誕生日おめでとう", 2, "お"},
+		{"estimator", Estimator{}, "one two three four", 3, "one two three "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetDefault(tt.t)
+			t.Cleanup(func() { SetDefault(nil) })
+			got := Truncate(tt.text, tt.tokens)
+			if got != tt.want {
+				t.Errorf("Truncate(%q, %d) = %q, want %q", tt.text, tt.tokens, got, tt.want)
+			}
+			if Count(got) > tt.tokens {
+				t.Errorf("Truncate(%q, %d) has %d tokens", tt.text, tt.tokens, Count(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"pplx2api/config"
+	"pplx2api/logger"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// IsRemoteURL reports whether an image_url is a http or https link rather than inline data
+func IsRemoteURL(value string) bool {
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
+
+// FetchImage downloads a remote image with the size limit, timeout and proxy from cfg.
+// It returns the image data and its content type.
+func FetchImage(cfg *config.Config, rawURL string) ([]byte, string, error) {
+	maxSize := int64(cfg.ImageFetchMaxMB) << 20
+	client, err := imageFetchClient(cfg.ImageFetchProxy, cfg.ImageFetchTimeout, cfg.ImageFetchAllowPrivate)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		// url.Error 中包含完整的 URL，只返回其中的错误
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, "", fmt.Errorf("image is larger than %d MB", cfg.ImageFetchMaxMB)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, "", fmt.Errorf("image is larger than %d MB", cfg.ImageFetchMaxMB)
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "" || contentType == "application/octet-stream" || contentType == "binary/octet-stream" {
+		// 部分对象存储不返回准确的类型，按文件内容判断
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("unsupported content type %q, expected an image", contentType)
+	}
+	return data, contentType, nil
+}
+
+// imageFetchClient creates the HTTP client used to download images
+func imageFetchClient(proxy string, timeout time.Duration, allowPrivate bool) (*http.Client, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image fetch proxy %s", logger.RedactURL(proxy))
+		}
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			// 经过代理时由代理连接目标，交给代理前先解析并检查目标地址；
+			// 代理会重新解析域名，需要严格防护时代理本身也应禁止访问内网
+			if !allowPrivate {
+				if err := checkPublicHost(req.Context(), req.URL.Hostname()); err != nil {
+					return nil, err
+				}
+			}
+			return proxyURL, nil
+		}
+	} else if !allowPrivate {
+		// 直连时禁止访问本机和内网地址，避免被用来探测内部服务
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return fmt.Errorf("access to %s is not allowed", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			if !IsRemoteURL(req.URL.String()) {
+				return fmt.Errorf("redirect to unsupported URL %q", logger.RedactURL(req.URL.String()))
+			}
+			return nil
+		},
+	}, nil
+}
+
+// checkPublicHost resolves host and rejects it when any of its addresses is local or private
+func checkPublicHost(ctx context.Context, host string) error {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return err
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return fmt.Errorf("access to %s is not allowed", host)
+		}
+	}
+	return nil
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast()
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"pplx2api/config"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchImageThroughProxy(t *testing.T) {
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}))
+	defer proxy.Close()
+
+	tests := []struct {
+		name         string
+		url          string
+		allowPrivate bool
+		wantErr      string
+		wantProxied  bool
+	}{
+		{"loopback address", "http://127.0.0.1:9/a.png?X-Amz-Signature=secret", false, "access to 127.0.0.1 is not allowed", false},
+		{"private address", "http://10.0.0.1/a.png", false, "access to 10.0.0.1 is not allowed", false},
+		{"localhost name", "http://localhost/a.png", false, "access to localhost is not allowed", false},
+		{"private allowed", "http://127.0.0.1:9/a.png", true, "", true},
+		{"public address", "http://93.184.216.34/a.png", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxied.Store(0)
+			cfg := &config.Config{
+				ImageFetchMaxMB:        1,
+				ImageFetchTimeout:      5 * time.Second,
+				ImageFetchProxy:        proxy.URL,
+				ImageFetchAllowPrivate: tt.allowPrivate,
+			}
+			_, _, err := FetchImage(cfg, tt.url)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("FetchImage() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("FetchImage() error = %v, want %q", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "secret") {
+				t.Errorf("error %q contains the query of the URL", err)
+			}
+			if got := proxied.Load() > 0; got != tt.wantProxied {
+				t.Errorf("request sent to the proxy = %t, want %t", got, tt.wantProxied)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package utils
+
+import "pplx2api/logger"
+
+// MaskSecret 隐藏密钥中间部分，只保留首尾少量字符
+func MaskSecret(secret string) string {
+	return logger.MaskSecret(secret)
+}
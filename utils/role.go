@@ -5,8 +5,8 @@ import (
 )
 
 // **获取角色前缀**
-func GetRolePrefix(role string) string {
-	if config.ConfigInstance.NoRolePrefix {
+func GetRolePrefix(cfg *config.Config, role string) string {
+	if cfg.NoRolePrefix {
 		return ""
 	}
 	switch role {
@@ -16,6 +16,8 @@ func GetRolePrefix(role string) string {
 		return "Human: "
 	case "assistant":
 		return "Assistant: "
+	case "tool":
+		return "Tool: "
 	default:
 		return "Unknown: "
 	}
@@ -13,13 +13,13 @@ func searchShowCompatible(index int, title, url, snippet string) string {
 	return fmt.Sprintf("[%d] [%s](%s):\n%s\n", index, title, url, snippet)
 }
 
-func SearchShow(index int, title, url, snippet string) string {
+func SearchShow(cfg *config.Config, index int, title, url, snippet string) string {
 	index++
 	if len([]rune(snippet)) > 150 {
 		runeSnippet := []rune(snippet)
 		snippet = fmt.Sprintf("%s ……", string(runeSnippet[:150]))
 	}
-	if config.ConfigInstance.SearchResultCompatible {
+	if cfg.SearchResultCompatible {
 		return searchShowCompatible(index, title, url, snippet)
 	}
 	return searchShowDetails(index, title, url, snippet)